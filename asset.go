@@ -0,0 +1,83 @@
+package tmplutil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"strings"
+)
+
+// asset returns the given asset path with a query string containing the hash
+// of the asset's content, e.g. "/static/app.css?v=0123456789ab". The leading
+// slash is trimmed when looking up the asset in Assets. If the asset cannot be
+// read, then the path is returned unchanged.
+func (tmpler *Templater) asset(path string) string {
+	// Hashes are cached along with the loaded templates, so that reloading
+	// them hashes the assets again.
+	t := tmpler.loaded()
+
+	if t != nil && !tmpler.debug() {
+		if v, ok := t.assetPaths.Load(path); ok {
+			return v.(string)
+		}
+	}
+
+	file, sep := path, "?"
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		file, sep = path[:i], "&"
+	}
+
+	b, err := fs.ReadFile(tmpler.Assets, strings.TrimPrefix(file, "/"))
+	if err != nil {
+		if tmpler.debug() {
+			tmpler.logf(slog.LevelWarn, "failed to read asset %q: %v", path, err)
+		}
+		return path
+	}
+
+	sum := sha256.Sum256(b)
+	hashed := path + sep + "v=" + hex.EncodeToString(sum[:6])
+	if t != nil {
+		t.assetPaths.Store(path, hashed)
+	}
+
+	return hashed
+}
+
+// include returns the content of the given file in the FileSystem as-is,
+// without parsing it as a template, e.g. to inline an SVG:
+//
+//	{{ include "icons/logo.svg" }}
+//
+// The path must not be rooted or contain "..". Since the content is not
+// escaped, only files as trusted as the templates themselves may be included.
+// Files are read once and cached until the templates are reloaded, e.g. by
+// OverrideAndReload, except in DebugMode.
+func (tmpler *Templater) include(path string) (template.HTML, error) {
+	if !fs.ValidPath(path) {
+		return "", fmt.Errorf("invalid include path %q", path)
+	}
+
+	t := tmpler.loaded()
+
+	if t != nil && !tmpler.debug() {
+		if v, ok := t.includedFiles.Load(path); ok {
+			return v.(template.HTML), nil
+		}
+	}
+
+	b, err := fs.ReadFile(tmpler.FileSystem, path)
+	if err != nil {
+		return "", err
+	}
+
+	html := template.HTML(b)
+	if t != nil {
+		t.includedFiles.Store(path, html)
+	}
+
+	return html, nil
+}
@@ -0,0 +1,60 @@
+package tmplutil
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAsset(t *testing.T) {
+	assets := fstest.MapFS{
+		"static/app.css": &fstest.MapFile{Data: []byte("body {}")},
+	}
+
+	tmpler := newTestTemplater(t, map[string]string{
+		"page.html": `<link href="{{ asset "/static/app.css" }}"> <img src="{{ asset "/missing.png?x=1" }}">`,
+	})
+	tmpler.Assets = assets
+
+	first := mustRender(t, tmpler, "page", nil)
+	if !strings.Contains(first, `href="/static/app.css?v=`) {
+		t.Errorf("expected a hashed asset path, got %q", first)
+	}
+	if !strings.Contains(first, `src="/missing.png?x=1"`) {
+		t.Errorf("expected a missing asset to be left as-is, got %q", first)
+	}
+
+	if again := mustRender(t, tmpler, "page", nil); again != first {
+		t.Errorf("expected the same output, got %q and %q", first, again)
+	}
+
+	assets["static/app.css"] = &fstest.MapFile{Data: []byte("body { color: red }")}
+	if err := tmpler.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if reloaded := mustRender(t, tmpler, "page", nil); reloaded == first {
+		t.Errorf("expected the hash to change after reloading, got %q", reloaded)
+	}
+}
+
+func TestInclude(t *testing.T) {
+	const logo = `<svg><text>{{ not a template }}</text></svg>`
+
+	tmpler := NewTemplater(mapFS(map[string]string{
+		"page.html":       `<header>{{ include "icons/logo.svg" }}</header>`,
+		"icons/logo.svg":  logo,
+		"icons/other.svg": `<svg></svg>`,
+	}))
+	tmpler.Register("page", "page.html")
+
+	if out := mustRender(t, tmpler, "page", nil); out != "<header>"+logo+"</header>" {
+		t.Errorf("expected the SVG to be inlined as-is, got %q", out)
+	}
+
+	for _, path := range []string{"/icons/logo.svg", "../icons/logo.svg"} {
+		if _, err := tmpler.include(path); err == nil {
+			t.Errorf("expected %q to be rejected", path)
+		}
+	}
+}
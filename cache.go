@@ -0,0 +1,111 @@
+package tmplutil
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultCacheSize is the CacheSize used if it's zero.
+const DefaultCacheSize = 256
+
+// CachedExecute executes the subtemplate like Execute, except the output is
+// cached under the given key for the given duration. The data function is only
+// called when the output isn't cached or has expired, so expensive data only
+// needs to be fetched once per duration. Keys are scoped to the subtemplate.
+//
+// At most CacheSize outputs are cached, with the least recently used ones
+// being evicted first. Nothing is cached in DebugMode.
+func (tmpler *Templater) CachedExecute(w io.Writer, tmpl, key string, ttl time.Duration, data func() interface{}) error {
+	if tmpler.debug() {
+		return tmpler.Execute(w, tmpl, data())
+	}
+
+	key = tmpl + "\x00" + key
+
+	if b, ok := tmpler.cache.get(key); ok {
+		_, err := w.Write(b)
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpler.execute(&buf, tmpl, data(), execOptions{}); err != nil {
+		tmpler.onRenderFail(w, tmpl, err)
+		return err
+	}
+
+	size := tmpler.CacheSize
+	if size == 0 {
+		size = DefaultCacheSize
+	}
+	tmpler.cache.put(key, buf.Bytes(), time.Now().Add(ttl), size)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// CachedExecute executes the subtemplate with output caching. Refer to
+// Templater.CachedExecute.
+func (sub *Subtemplate) CachedExecute(w io.Writer, key string, ttl time.Duration, data func() interface{}) error {
+	return sub.tmpl.CachedExecute(w, sub.name, key, ttl, data)
+}
+
+// pageCache is an LRU cache of rendered outputs.
+type pageCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     list.List // of *cachedPage, most recently used first
+}
+
+type cachedPage struct {
+	key     string
+	output  []byte
+	expires time.Time
+}
+
+func (c *pageCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	page := elem.Value.(*cachedPage)
+	if time.Now().After(page.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+	return page.output, true
+}
+
+func (c *pageCache) put(key string, output []byte, expires time.Time, size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element)
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		page := elem.Value.(*cachedPage)
+		page.output = output
+		page.expires = expires
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&cachedPage{key, output, expires})
+
+	for c.lru.Len() > size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedPage).key)
+	}
+}
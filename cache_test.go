@@ -0,0 +1,65 @@
+package tmplutil
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCachedExecute(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"page.html": `<p>{{ . }}</p>`,
+	})
+	page := tmpler.Subtemplate("page")
+
+	var calls int
+	data := func() interface{} {
+		calls++
+		return calls
+	}
+
+	for i := 0; i < 3; i++ {
+		var buf strings.Builder
+		if err := page.CachedExecute(&buf, "key", time.Hour, data); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != "<p>1</p>" {
+			t.Errorf("expected the cached output, got %q", buf.String())
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("expected data to be called once within the TTL, got %d calls", calls)
+	}
+
+	var buf strings.Builder
+	if err := page.CachedExecute(&buf, "other", time.Hour, data); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "<p>2</p>" {
+		t.Errorf("expected another key to be rendered anew, got %q", buf.String())
+	}
+}
+
+func TestCachedExecuteExpired(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"page.html": `{{ . }}`,
+	})
+
+	var calls int
+	data := func() interface{} {
+		calls++
+		return calls
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := tmpler.CachedExecute(&strings.Builder{}, "page", "key", time.Millisecond, data); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	if calls != 2 {
+		t.Errorf("expected data to be called again after the TTL, got %d calls", calls)
+	}
+}
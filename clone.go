@@ -0,0 +1,124 @@
+package tmplutil
+
+import (
+	"fmt"
+	"html/template"
+)
+
+// Clone returns a copy of the Templater with its own includes, functions and
+// parsed templates. The clone's Functions may be changed before it is first
+// executed, e.g. to bind a function to the current request, without affecting
+// the original:
+//
+//	clone, err := tmpler.Clone()
+//	if err != nil {
+//		return err
+//	}
+//	clone.Functions["csrf"] = func() string { return token }
+//
+// The clone parses the templates again on its first use from the sources that
+// the original has already read, so cloning doesn't read any files, but the
+// clone costs as much as parsing the templates. The original may be cloned at
+// any time, including while it's executing, and per-request clones may be
+// pooled if parsing is too expensive. Clone returns an error if the original's
+// templates fail to load.
+func (tmpler *Templater) Clone() (*Templater, error) {
+	// Load the templates to make sure that their sources have been read.
+	if err := tmpler.Ready(); err != nil {
+		return nil, err
+	}
+
+	tmpler.tmplMu.Lock()
+	defer tmpler.tmplMu.Unlock()
+
+	clone := &Templater{
+		FileSystem:               tmpler.FileSystem,
+		Includes:                 copyMap(tmpler.Includes),
+		Extensions:               tmpler.Extensions,
+		Functions:                template.FuncMap(copyMap(tmpler.Functions)),
+		Debug:                    tmpler.Debug,
+		Logger:                   tmpler.Logger,
+		OnRenderFail:             tmpler.OnRenderFail,
+		Errors:                   tmpler.Errors,
+		BufferRenders:            tmpler.BufferRenders,
+		RenderTimeout:            tmpler.RenderTimeout,
+		RecoverPanics:            tmpler.RecoverPanics,
+		CacheSize:                tmpler.CacheSize,
+		DebugModTime:             tmpler.DebugModTime,
+		OnRender:                 tmpler.OnRender,
+		OnParse:                  tmpler.OnParse,
+		TextMode:                 tmpler.TextMode,
+		StrictNames:              tmpler.StrictNames,
+		ValidateExecute:          tmpler.ValidateExecute,
+		TrackUsage:               tmpler.TrackUsage,
+		StrictReferences:         tmpler.StrictReferences,
+		MissingKey:               tmpler.MissingKey,
+		Assets:                   tmpler.Assets,
+		PostProcessors:           copyMap(tmpler.PostProcessors),
+		PostProcessorFallback:    tmpler.PostProcessorFallback,
+		Translations:             copyMap(tmpler.Translations),
+		GlobalData:               tmpler.GlobalData,
+		Minifier:                 tmpler.Minifier,
+		MinifyTypes:              tmpler.MinifyTypes,
+		StripHTMLComments:        tmpler.StripHTMLComments,
+		OutputFilters:            tmpler.OutputFilters,
+		SkipOutputFiltersInDebug: tmpler.SkipOutputFiltersInDebug,
+		delims:                   tmpler.delims,
+		layouts:                  copyMap(tmpler.layouts),
+		pageFns:                  copyMap(tmpler.pageFns),
+		sandbox:                  copyMap(tmpler.sandbox),
+		strSrcs:                  copyMap(tmpler.strSrcs),
+		sources:                  copyMap(tmpler.sources),
+		contextFuncs:             copyMap(tmpler.contextFuncs),
+	}
+
+	return clone, nil
+}
+
+// clone returns a copy of the templates. It fails if the templates have already
+// been executed.
+func (t *templates) clone() (*templates, error) {
+	set, err := t.set.clone()
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone: %w", err)
+	}
+
+	layouts := make(map[string]layoutTemplate, len(t.layouts))
+	for name, layout := range t.layouts {
+		layoutSet, err := layout.set.clone()
+		if err != nil {
+			return nil, fmt.Errorf("failed to clone page %q: %w", name, err)
+		}
+		layouts[name] = layoutTemplate{layoutSet, layout.name}
+	}
+
+	cpy := &templates{
+		includes:   t.includes,
+		set:        set,
+		layouts:    layouts,
+		processors: t.processors,
+		pageFns:    t.pageFns,
+		binders:    t.binders,
+		version:    t.version,
+	}
+
+	if t.pristine != nil {
+		if cpy.pristine, err = t.pristine.clone(); err != nil {
+			return nil, err
+		}
+	}
+
+	return cpy, nil
+}
+
+func copyMap[K comparable, V any](m map[K]V) map[K]V {
+	if m == nil {
+		return nil
+	}
+
+	cpy := make(map[K]V, len(m))
+	for k, v := range m {
+		cpy[k] = v
+	}
+	return cpy
+}
@@ -0,0 +1,38 @@
+package tmplutil
+
+import "testing"
+
+func TestClone(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"page.html": `{{ greet }}`,
+	})
+	tmpler.Functions["greet"] = func() string { return "hello" }
+
+	// Execute the original first, since per-request clones are made from a
+	// Templater that is already serving.
+	if out := mustRender(t, tmpler, "page", nil); out != "hello" {
+		t.Fatalf("unexpected output %q", out)
+	}
+
+	clones := make([]*Templater, 2)
+	for i, greeting := range []string{"bonjour", "hallo"} {
+		clone, err := tmpler.Clone()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		greeting := greeting
+		clone.Functions["greet"] = func() string { return greeting }
+		clones[i] = clone
+	}
+
+	if out := mustRender(t, clones[0], "page", nil); out != "bonjour" {
+		t.Errorf("expected the first clone's function, got %q", out)
+	}
+	if out := mustRender(t, clones[1], "page", nil); out != "hallo" {
+		t.Errorf("expected the second clone's function, got %q", out)
+	}
+	if out := mustRender(t, tmpler, "page", nil); out != "hello" {
+		t.Errorf("expected the original to be unaffected, got %q", out)
+	}
+}
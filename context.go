@@ -0,0 +1,254 @@
+package tmplutil
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"reflect"
+)
+
+// ContextFunc is a template function that is bound to the context given to
+// ExecuteContext.
+type ContextFunc func(ctx context.Context) interface{}
+
+// unboundContextFunc returns the function that stands in for a context function
+// at parse time and when the template is executed without a context.
+func unboundContextFunc(name string) func(...interface{}) (interface{}, error) {
+	return func(...interface{}) (interface{}, error) {
+		return nil, fmt.Errorf("function %q requires ExecuteContext", name)
+	}
+}
+
+// RegisterContextFunc registers a template function that returns fn's result
+// for the context given to ExecuteContext, e.g. the current user or the CSRF
+// token of the request:
+//
+//	tmpler.RegisterContextFunc("csrfToken", func(ctx context.Context) interface{} {
+//		return csrf.TokenFromContext(ctx)
+//	})
+//
+// Templates using it must be executed using ExecuteContext, which Handler does;
+// executing them otherwise fails. It should only be called before preloading.
+//
+// Since the functions of a parsed template cannot be swapped while another
+// goroutine executes it, every ExecuteContext call needs its own copy of the
+// templates to bind the functions to, as long as any template calls a context
+// function, "t", "render" or "capture". Copies are pooled and
+// reused, but every concurrent ExecuteContext call beyond the pool's size
+// clones all parse trees, which costs about as much as parsing them again.
+func (tmpler *Templater) RegisterContextFunc(name string, fn func(ctx context.Context) interface{}) {
+	if tmpler.contextFuncs == nil {
+		tmpler.contextFuncs = make(map[string]ContextFunc)
+	}
+	tmpler.contextFuncs[name] = fn
+}
+
+// bind returns a copy of the templates with the context functions bound to the
+// given execution. The copy should be given back using unbind once it's no
+// longer used.
+func (t *templates) bind(opts execOptions) (*templates, error) {
+	bound, ok := t.bound.Get().(*templates)
+	if !ok {
+		var err error
+		if bound, err = t.pristine.clone(); err != nil {
+			return nil, err
+		}
+	}
+
+	funcs := make(template.FuncMap, len(t.binders))
+	for name, bind := range t.binders {
+		funcs[name] = bind(opts)
+	}
+
+	bound.funcs(funcs)
+	return bound, nil
+}
+
+// unbind puts the copy returned by bind back into the pool.
+func (t *templates) unbind(bound *templates) {
+	t.bound.Put(bound)
+}
+
+// contextBinder returns a template function bound to the given execution. The
+// function given at parse time is bound to the zero execOptions.
+type contextBinder func(opts execOptions) interface{}
+
+// contextBinders returns the binders of all context functions, including the
+// "t" function if there are Translations, and the "render" and "capture"
+// functions.
+func (tmpler *Templater) contextBinders() map[string]contextBinder {
+	binders := make(map[string]contextBinder, len(tmpler.contextFuncs)+4)
+
+	for name, fn := range tmpler.contextFuncs {
+		name, fn := name, fn
+		binders[name] = func(opts execOptions) interface{} {
+			if opts.ctx == nil {
+				return unboundContextFunc(name)
+			}
+			return func() interface{} { return fn(opts.ctx) }
+		}
+	}
+
+	if _, ok := tmpler.Functions["t"]; !ok && tmpler.Translations != nil {
+		translations := copyMap(tmpler.Translations)
+		binders["t"] = func(opts execOptions) interface{} {
+			if opts.ctx == nil {
+				return unboundContextFunc("t")
+			}
+			return tmpler.translator(translations, opts.ctx)
+		}
+	}
+
+	if _, ok := tmpler.Functions["render"]; !ok {
+		binders["render"] = tmpler.renderFunc
+	}
+
+	if _, ok := tmpler.Functions["capture"]; !ok {
+		binders["capture"] = tmpler.captureFunc
+	}
+
+	return binders
+}
+
+// usedBinders returns the given binders without the ones whose functions are
+// never called within the templates.
+func usedBinders(binders map[string]contextBinder, t *templates) map[string]contextBinder {
+	used := funcNames(t)
+	for name := range binders {
+		if _, ok := used[name]; !ok {
+			delete(binders, name)
+		}
+	}
+	return binders
+}
+
+// MaxRenderDepth is the maximum number of nested calls to the "render" and
+// "capture" functions, which stops templates from rendering each other
+// endlessly.
+var MaxRenderDepth = 16
+
+// renderFunc returns the "render" function, which executes an include with the
+// given data through the whole pipeline, including its PostProcessors, and
+// returns its output. Unlike {{ template }}, this allows embedding e.g. a
+// markdown include within an HTML page:
+//
+//	<article>{{ render "intro" . }}</article>
+func (tmpler *Templater) renderFunc(opts execOptions) interface{} {
+	return func(name string, v interface{}) (template.HTML, error) {
+		if opts.depth >= MaxRenderDepth {
+			return "", fmt.Errorf("render %q: exceeded maximum depth %d", name, MaxRenderDepth)
+		}
+
+		buf := getBuffer()
+		defer putBuffer(buf)
+
+		nested := execOptions{ctx: opts.ctx, depth: opts.depth + 1}
+		if err := tmpler.execute(buf, name, v, nested); err != nil {
+			return "", err
+		}
+
+		return template.HTML(buf.String()), nil
+	}
+}
+
+// captureFunc returns the "capture" function, which executes any template,
+// including blocks defined within includes, with the given data and returns its
+// output, so that it can be stored in a variable and used more than once:
+//
+//	{{ $title := capture "title" . }}
+//	<title>{{ $title }}</title>
+//	<h1>{{ $title }}</h1>
+//
+// Unlike "render", the output doesn't go through PostProcessors, and blocks
+// defined by pages registered with a layout cannot be captured.
+func (tmpler *Templater) captureFunc(opts execOptions) interface{} {
+	return func(name string, v interface{}) (template.HTML, error) {
+		if opts.depth >= MaxRenderDepth {
+			return "", fmt.Errorf("capture %q: exceeded maximum depth %d", name, MaxRenderDepth)
+		}
+
+		t := tmpler.load()
+
+		nested := execOptions{ctx: opts.ctx, depth: opts.depth + 1}
+		if t.pristine != nil {
+			bound, err := t.bind(nested)
+			if err != nil {
+				return "", err
+			}
+			defer t.unbind(bound)
+			t = bound
+		}
+
+		buf := getBuffer()
+		defer putBuffer(buf)
+
+		if err := t.set.ExecuteTemplate(buf, name, v); err != nil {
+			return "", err
+		}
+
+		return template.HTML(buf.String()), nil
+	}
+}
+
+// withGlobal returns the data with the GlobalData for the given context merged
+// in under "Global". Refer to Templater.GlobalData.
+func (tmpler *Templater) withGlobal(ctx context.Context, v interface{}) interface{} {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if v == nil {
+		return map[string]interface{}{"Global": tmpler.GlobalData(ctx)}
+	}
+
+	rv := reflect.ValueOf(v)
+
+	switch {
+	case rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String:
+		if rv.MapIndex(reflect.ValueOf("Global").Convert(rv.Type().Key())).IsValid() {
+			return v
+		}
+
+		merged := make(map[string]interface{}, rv.Len()+1)
+		for iter := rv.MapRange(); iter.Next(); {
+			merged[iter.Key().String()] = iter.Value().Interface()
+		}
+		merged["Global"] = tmpler.GlobalData(ctx)
+
+		return merged
+
+	case rv.Kind() == reflect.Struct:
+		if cpy, ok := tmpler.structWithGlobal(ctx, rv); ok {
+			return cpy.Elem().Interface()
+		}
+
+	case rv.Kind() == reflect.Pointer && !rv.IsNil() && rv.Elem().Kind() == reflect.Struct:
+		if cpy, ok := tmpler.structWithGlobal(ctx, rv.Elem()); ok {
+			return cpy.Interface()
+		}
+	}
+
+	return v
+}
+
+var globalType = reflect.TypeOf(map[string]interface{}(nil))
+
+// structWithGlobal returns a pointer to a copy of the struct with its Global
+// field set to the GlobalData. It returns false if the struct has no such
+// field or if it's already set.
+func (tmpler *Templater) structWithGlobal(ctx context.Context, rv reflect.Value) (reflect.Value, bool) {
+	field, ok := rv.Type().FieldByName("Global")
+	if !ok || !field.IsExported() || field.Type != globalType {
+		return reflect.Value{}, false
+	}
+
+	if !rv.FieldByIndex(field.Index).IsNil() {
+		return reflect.Value{}, false
+	}
+
+	cpy := reflect.New(rv.Type())
+	cpy.Elem().Set(rv)
+	cpy.Elem().FieldByIndex(field.Index).Set(reflect.ValueOf(tmpler.GlobalData(ctx)))
+
+	return cpy, true
+}
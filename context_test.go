@@ -0,0 +1,242 @@
+package tmplutil
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// upperMarkdown is a stand-in for a markdown converter, which turns "# " lines
+// into headings.
+func upperMarkdown(in []byte, w io.Writer) error {
+	for _, line := range strings.Split(strings.TrimSpace(string(in)), "\n") {
+		if heading, ok := strings.CutPrefix(line, "# "); ok {
+			line = "<h1>" + heading + "</h1>"
+		} else {
+			line = "<p>" + line + "</p>"
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestRender(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"page.html":  `<article>{{ render "intro" .Name }}</article>`,
+		"intro.md":   "# Hello\n{{ . }} & co",
+		"loop.html":  `{{ render "loop" . }}`,
+		"plain.html": `{{ . }}`,
+	})
+	tmpler.PostProcessors = map[string]PostProcessor{".md": upperMarkdown}
+
+	out := mustRender(t, tmpler, "page", map[string]string{"Name": "alice"})
+	if out != "<article><h1>Hello</h1><p>alice & co</p></article>" {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	if _, err := tmpler.RenderString("loop", nil); err == nil || !strings.Contains(err.Error(), "maximum depth") {
+		t.Errorf("expected the recursion to be stopped, got %v", err)
+	}
+}
+
+func TestCapture(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"page.html": `{{ define "title" }}{{ .Site }} - {{ .Page }}{{ end }}` +
+			`{{ $title := capture "title" . }}<title>{{ $title }}</title><h1>{{ $title }}</h1>`,
+	})
+
+	out := mustRender(t, tmpler, "page", map[string]string{"Site": "Blog", "Page": "Home"})
+	if out != "<title>Blog - Home</title><h1>Blog - Home</h1>" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+func TestContextFunc(t *testing.T) {
+	type userKey struct{}
+
+	tmpler := newTestTemplater(t, map[string]string{
+		"page.html": `<p>{{ user }}</p>`,
+	})
+	tmpler.RegisterContextFunc("user", func(ctx context.Context) interface{} {
+		return ctx.Value(userKey{})
+	})
+
+	for _, user := range []string{"alice", "bob"} {
+		var buf bytes.Buffer
+		ctx := context.WithValue(context.Background(), userKey{}, user)
+		if err := tmpler.ExecuteContext(ctx, &buf, "page", nil); err != nil {
+			t.Fatal(err)
+		}
+		if out := buf.String(); out != "<p>"+user+"</p>" {
+			t.Errorf("expected %q, got %q", user, out)
+		}
+	}
+
+	if err := tmpler.Execute(io.Discard, "page", nil); err == nil {
+		t.Error("expected executing without a context to fail")
+	}
+}
+
+func TestBindOnlyUsedFuncs(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		binds bool
+	}{
+		{"no functions", `<p>{{ . }}</p>`, false},
+		{"other functions", `<p>{{ upper . }}</p>`, false},
+		{"render", `<p>{{ render "other" . }}</p>`, true},
+		{"capture within block", `{{ if . }}{{ capture "other" . }}{{ end }}`, true},
+		{"context function", `{{ with . }}{{ user | upper }}{{ end }}`, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpler := newTestTemplater(t, map[string]string{
+				"page.html":  test.src,
+				"other.html": `other`,
+			})
+			tmpler.Functions["upper"] = strings.ToUpper
+			tmpler.RegisterContextFunc("user", func(ctx context.Context) interface{} {
+				return "alice"
+			})
+
+			if binds := tmpler.load().pristine != nil; binds != test.binds {
+				t.Errorf("expected binding to be %v, got %v", test.binds, binds)
+			}
+		})
+	}
+}
+
+type globalPage struct {
+	Title  string
+	Global map[string]interface{}
+}
+
+func TestGlobalData(t *testing.T) {
+	type userKey struct{}
+
+	tests := []struct {
+		name string
+		tmpl string
+		data interface{}
+		out  string
+	}{
+		{"nil", "footer", nil, "<footer>2026 alice</footer>"},
+		{"map", "page", map[string]string{"Title": "Home"}, "<h1>Home</h1><footer>2026 alice</footer>"},
+		{"struct", "page", globalPage{Title: "Home"}, "<h1>Home</h1><footer>2026 alice</footer>"},
+		{"struct pointer", "page", &globalPage{Title: "Home"}, "<h1>Home</h1><footer>2026 alice</footer>"},
+		{
+			name: "map with Global",
+			tmpl: "footer",
+			data: map[string]interface{}{"Global": map[string]interface{}{"Year": 1999, "User": "bob"}},
+			out:  "<footer>1999 bob</footer>",
+		},
+		{
+			name: "struct with Global",
+			tmpl: "footer",
+			data: globalPage{Global: map[string]interface{}{"Year": 1999, "User": "bob"}},
+			out:  "<footer>1999 bob</footer>",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpler := newTestTemplater(t, map[string]string{
+				"page.html":   `<h1>{{ .Title }}</h1>{{ template "footer" . }}`,
+				"footer.html": `<footer>{{ .Global.Year }} {{ .Global.User }}</footer>`,
+			})
+			tmpler.GlobalData = func(ctx context.Context) map[string]interface{} {
+				return map[string]interface{}{
+					"Year": 2026,
+					"User": ctx.Value(userKey{}),
+				}
+			}
+
+			var buf bytes.Buffer
+			ctx := context.WithValue(context.Background(), userKey{}, "alice")
+			if err := tmpler.ExecuteContext(ctx, &buf, test.tmpl, test.data); err != nil {
+				t.Fatal(err)
+			}
+
+			if out := buf.String(); out != test.out {
+				t.Errorf("expected %q, got %q", test.out, out)
+			}
+		})
+	}
+}
+
+func TestGlobalDataExecute(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"footer.html": `<footer>{{ .Global.Year }}</footer>`,
+	})
+	tmpler.GlobalData = func(ctx context.Context) map[string]interface{} {
+		return map[string]interface{}{"Year": 2026}
+	}
+
+	if out := mustRender(t, tmpler, "footer", nil); out != "<footer>2026</footer>" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	tmpler := NewTemplater(mapFS(map[string]string{}))
+
+	if err := tmpler.RenderMarkdown(io.Discard, []byte("# Hi")); err == nil {
+		t.Error("expected an error without a markdown PostProcessor")
+	}
+
+	tmpler.PostProcessors = map[string]PostProcessor{".md": upperMarkdown}
+
+	var buf strings.Builder
+	// The source isn't executed as a template.
+	if err := tmpler.RenderMarkdown(&buf, []byte("# {{ . }}\nbody")); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "<h1>{{ . }}</h1><p>body</p>" {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
+
+// lazyMarkdown returns a stand-in for a markdown converter that sets itself up
+// on its first conversion, like goldmark loading chroma lexers, along with the
+// number of times it was set up.
+func lazyMarkdown() (PostProcessor, *int) {
+	var once sync.Once
+	var setups int
+
+	return func(in []byte, w io.Writer) error {
+		once.Do(func() { setups++ })
+		return upperMarkdown(in, w)
+	}, &setups
+}
+
+func TestWarmMarkdown(t *testing.T) {
+	tmpler := NewTemplater(mapFS(map[string]string{}))
+
+	if err := tmpler.WarmMarkdown(); err != nil {
+		t.Errorf("expected no error without a markdown PostProcessor, got %v", err)
+	}
+
+	process, setups := lazyMarkdown()
+	tmpler.PostProcessors = map[string]PostProcessor{".md": process}
+
+	if err := tmpler.WarmMarkdown(); err != nil {
+		t.Fatal(err)
+	}
+	if *setups != 1 {
+		t.Fatalf("expected WarmMarkdown to set up the converter, got %d setups", *setups)
+	}
+
+	if err := tmpler.RenderMarkdown(io.Discard, []byte("# Hello")); err != nil {
+		t.Fatal(err)
+	}
+	if *setups != 1 {
+		t.Errorf("expected the first real conversion not to set up again, got %d setups", *setups)
+	}
+}
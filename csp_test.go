@@ -0,0 +1,85 @@
+package tmplutil
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAddNonce(t *testing.T) {
+	tests := []struct {
+		policy string
+		out    string
+	}{
+		{"", "script-src 'nonce-abc'; style-src 'nonce-abc'"},
+		{
+			"default-src 'self'; img-src *",
+			"default-src 'self'; img-src *; script-src 'self' 'nonce-abc'; style-src 'self' 'nonce-abc'",
+		},
+		{
+			"default-src 'none'; script-src 'self'",
+			"default-src 'none'; script-src 'self' 'nonce-abc'; style-src 'nonce-abc'",
+		},
+		{
+			"script-src 'self' https://cdn.example.com; style-src 'none'",
+			"script-src 'self' https://cdn.example.com 'nonce-abc'; style-src 'nonce-abc'",
+		},
+		{
+			"Script-Src 'self'; script-src 'unsafe-eval';",
+			"Script-Src 'self' 'nonce-abc'; script-src 'unsafe-eval'; style-src 'nonce-abc'",
+		},
+	}
+
+	for _, test := range tests {
+		if out := addNonce(test.policy, "abc"); out != test.out {
+			t.Errorf("policy %q: expected %q, got %q", test.policy, test.out, out)
+		}
+	}
+}
+
+func TestCSPNonce(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"page.html": `<script nonce="{{ nonce }}"></script>`,
+	})
+	tmpler.UseCSPNonce()
+
+	var nonce string
+	handler := CSPNonce(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = NonceFromContext(r.Context())
+		tmpler.ExecuteContext(r.Context(), w, "page", nil)
+	}))
+
+	// An earlier middleware sets its own policy, which must be kept.
+	handler = withHeader(handler, "Content-Security-Policy", "default-src 'self'")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if nonce == "" {
+		t.Fatal("expected a nonce in the context")
+	}
+	if body := rec.Body.String(); body != `<script nonce="`+nonce+`"></script>` {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	policies := rec.Header().Values("Content-Security-Policy")
+	if len(policies) != 1 {
+		t.Fatalf("expected one policy, got %q", policies)
+	}
+	if !strings.HasPrefix(policies[0], "default-src 'self'; ") || !strings.Contains(policies[0], "script-src 'self' 'nonce-"+nonce+"'") {
+		t.Errorf("expected the nonce to be merged into the policy, got %q", policies[0])
+	}
+
+	if NonceFromContext(context.Background()) != "" {
+		t.Error("expected no nonce without CSPNonce")
+	}
+}
+
+func withHeader(next http.Handler, key, value string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(key, value)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,31 +1,203 @@
 package tmplutil
 
 import (
+	"errors"
+	"io"
 	"io/fs"
+	"os"
 	"path/filepath"
+	"sort"
 )
 
-type overrideFS struct {
-	base     fs.FS
-	override fs.FS
-}
+// overrideFS is a list of filesystems, with later ones taking precedence.
+type overrideFS []fs.FS
 
 // OverrideFS creates a new filesystem that overrides base. This is useful for
-// letting the user override certain template files.
-func OverrideFS(base, override fs.FS) fs.FS {
-	return overrideFS{base, override}
+// letting the user override certain template files. If multiple overrides are
+// given, then later ones take precedence over earlier ones, e.g. a base theme,
+// then the active theme, then the user's customizations. A file missing from
+// one layer falls through to the next, but any other error, such as a
+// permission error, is returned as-is.
+func OverrideFS(base fs.FS, overrides ...fs.FS) fs.FS {
+	var ov overrideFS
+	// Flatten nested overrides so that the precedence stays obvious.
+	if baseOv, ok := base.(overrideFS); ok {
+		ov = append(ov, baseOv...)
+	} else {
+		ov = append(ov, base)
+	}
+	return append(ov, overrides...)
 }
 
 func (ov overrideFS) Open(name string) (fs.File, error) {
-	f, err := ov.override.Open(name)
-	if err != nil {
-		f, err = ov.base.Open(name)
+	var err error
+	for i := len(ov) - 1; i >= 0; i-- {
+		var f fs.File
+		f, err = ov[i].Open(name)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return f, err
+		}
 	}
-	return f, err
+	return nil, err
+}
+
+// mergeFS is a list of filesystems whose files are combined.
+type mergeFS []fs.FS
+
+// MergeFS creates a new filesystem that combines the files of all given
+// filesystems, e.g. core templates and plugin templates that live in separate
+// embed.FS. Each file is opened from the first filesystem that has it, while
+// directories list the files of all filesystems, so Preregister finds the
+// templates of every filesystem. Unlike OverrideFS, the filesystems are meant
+// not to overlap. Errors other than a missing file are returned as-is.
+func MergeFS(filesystems ...fs.FS) fs.FS {
+	return mergeFS(filesystems)
+}
+
+func (m mergeFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	for _, fsys := range m {
+		f, err := fsys.Open(name)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+
+		stat, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		if !stat.IsDir() {
+			return f, nil
+		}
+
+		f.Close()
+
+		entries, err := m.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &mergedDir{stat, entries}, nil
+	}
+
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir implements fs.ReadDirFS. It lists the entries of the directory in
+// all filesystems that have it, sorted by name. If multiple filesystems have
+// an entry with the same name, then the first one is listed.
+func (m mergeFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	var found bool
+	seen := make(map[string]bool)
+
+	for _, fsys := range m {
+		dirEntries, err := fs.ReadDir(fsys, name)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+
+		found = true
+		for _, entry := range dirEntries {
+			if !seen[entry.Name()] {
+				seen[entry.Name()] = true
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, nil
+}
+
+// mergedDir is a directory opened from a mergeFS.
+type mergedDir struct {
+	stat    fs.FileInfo
+	entries []fs.DirEntry
+}
+
+func (d *mergedDir) Stat() (fs.FileInfo, error) { return d.stat, nil }
+func (d *mergedDir) Close() error               { return nil }
+
+func (d *mergedDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.stat.Name(), Err: fs.ErrInvalid}
+}
+
+func (d *mergedDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// WritableFS is a filesystem created by WritableOverrideFS that can be written
+// to.
+type WritableFS struct {
+	overrideFS
+	dir string
+}
+
+// WritableOverrideFS creates a new filesystem that overrides base with the
+// files in the given directory like OverrideFS, except files can also be
+// written into the directory using WriteFile, e.g. to let admins edit templates
+// while keeping base untouched.
+func WritableOverrideFS(base fs.FS, overrideDir string) *WritableFS {
+	ov := OverrideFS(base, os.DirFS(overrideDir)).(overrideFS)
+	return &WritableFS{ov, overrideDir}
+}
+
+// WriteFile writes the file with the given name into the override directory,
+// creating any parent directories as needed. The name must be valid according
+// to fs.ValidPath. Templates are not reloaded by writing; use Invalidate or
+// Watch for that.
+func (w *WritableFS) WriteFile(name string, data []byte) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+
+	dst := filepath.Join(w.dir, filepath.FromSlash(name))
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(dst, data, 0644)
 }
 
 // FilterFileTypes creates a new filesystem that only contains files with the
-// given file types.
+// given file types. Directories are kept, but listing them only yields the
+// files with the given file types, so walking the filesystem using fs.WalkDir
+// only discovers those files.
 func FilterFileTypes(fs fs.FS, fileTypes ...string) fs.FS {
 	return filterFS{fs, fileTypes}
 }
@@ -58,9 +230,26 @@ func (f filterFS) Open(name string) (fs.File, error) {
 		return nil, err
 	}
 
-	if !isFileType(stat.Name(), f.fileTypes) {
+	if !stat.IsDir() && !isFileType(stat.Name(), f.fileTypes) {
+		file.Close()
 		return nil, fs.ErrNotExist
 	}
 
 	return file, nil
 }
+
+func (f filterFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(f.fs, name)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || isFileType(entry.Name(), f.fileTypes) {
+			filtered = append(filtered, entry)
+		}
+	}
+
+	return filtered, nil
+}
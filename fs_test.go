@@ -0,0 +1,186 @@
+package tmplutil
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func mapFS(files map[string]string) fstest.MapFS {
+	fsys := make(fstest.MapFS, len(files))
+	for path, data := range files {
+		fsys[path] = &fstest.MapFile{Data: []byte(data)}
+	}
+	return fsys
+}
+
+func TestOverrideFSLayers(t *testing.T) {
+	base := mapFS(map[string]string{
+		"a.html": "base a",
+		"b.html": "base b",
+		"c.html": "base c",
+	})
+	theme := mapFS(map[string]string{
+		"b.html": "theme b",
+		"c.html": "theme c",
+	})
+	user := mapFS(map[string]string{
+		"c.html": "user c",
+	})
+
+	tests := []struct {
+		name string
+		fsys fs.FS
+	}{
+		{"variadic", OverrideFS(base, theme, user)},
+		{"nested", OverrideFS(OverrideFS(base, theme), user)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expected := map[string]string{
+				"a.html": "base a",
+				"b.html": "theme b",
+				"c.html": "user c",
+			}
+			for name, data := range expected {
+				b, err := fs.ReadFile(test.fsys, name)
+				if err != nil {
+					t.Errorf("failed to read %s: %v", name, err)
+					continue
+				}
+				if string(b) != data {
+					t.Errorf("expected %s to be %q, got %q", name, data, b)
+				}
+			}
+
+			if _, err := fs.ReadFile(test.fsys, "missing.html"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("expected a missing file to not exist, got %v", err)
+			}
+		})
+	}
+}
+
+// errorFS fails to open any file with the given error.
+type errorFS struct{ err error }
+
+func (e errorFS) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: e.err}
+}
+
+func TestOverrideFSErrors(t *testing.T) {
+	base := mapFS(map[string]string{"a.html": "base a"})
+
+	_, err := fs.ReadFile(OverrideFS(base, errorFS{fs.ErrPermission}), "a.html")
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected the permission error to be returned, got %v", err)
+	}
+
+	b, err := fs.ReadFile(OverrideFS(base, errorFS{fs.ErrNotExist}), "a.html")
+	if err != nil || string(b) != "base a" {
+		t.Errorf("expected a missing file to fall through, got %q, %v", b, err)
+	}
+}
+
+func TestFilterFileTypes(t *testing.T) {
+	fsys := FilterFileTypes(mapFS(map[string]string{
+		"index.html":       "",
+		"style.css":        "",
+		"blog/post.html":   "",
+		"blog/image.png":   "",
+		"blog/draft.md":    "",
+		"empty/readme.txt": "",
+	}), ".html")
+
+	var found []string
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			found = append(found, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(found, ","); got != "blog/post.html,index.html" {
+		t.Errorf("expected only the .html files, got %q", got)
+	}
+
+	if _, err := fsys.Open("style.css"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected a filtered file to not exist, got %v", err)
+	}
+}
+
+func TestWritableOverrideFS(t *testing.T) {
+	base := mapFS(map[string]string{
+		"page.html":         "base page",
+		"partials/nav.html": "base nav",
+	})
+	dir := t.TempDir()
+
+	wfs := WritableOverrideFS(base, dir)
+
+	if err := wfs.WriteFile("partials/nav.html", []byte("edited nav")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wfs.WriteFile("../escape.html", nil); err == nil {
+		t.Error("expected an invalid name to be rejected")
+	}
+
+	files := map[string]string{
+		"page.html":         "base page",
+		"partials/nav.html": "edited nav",
+	}
+	for name, expect := range files {
+		b, err := fs.ReadFile(wfs, name)
+		if err != nil || string(b) != expect {
+			t.Errorf("%s: expected %q, got %q, %v", name, expect, b, err)
+		}
+	}
+
+	if b, _ := fs.ReadFile(base, "partials/nav.html"); string(b) != "base nav" {
+		t.Errorf("expected base to be untouched, got %q", b)
+	}
+}
+
+func TestMergeFS(t *testing.T) {
+	core := mapFS(map[string]string{
+		"index.html":        "core index",
+		"partials/nav.html": "core nav",
+	})
+	plugin := mapFS(map[string]string{
+		"plugin/page.html":   "plugin page",
+		"partials/card.html": "plugin card",
+	})
+
+	merged := MergeFS(core, plugin)
+
+	var found []string
+	err := fs.WalkDir(merged, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			found = append(found, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{"index.html", "partials/card.html", "partials/nav.html", "plugin/page.html"}
+	if strings.Join(found, ",") != strings.Join(expect, ",") {
+		t.Errorf("expected %q, got %q", expect, found)
+	}
+
+	if b, err := fs.ReadFile(merged, "partials/card.html"); err != nil || string(b) != "plugin card" {
+		t.Errorf("expected the plugin file, got %q, %v", b, err)
+	}
+}
@@ -0,0 +1,175 @@
+package tmplutil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/url"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultFuncs returns a new map of commonly used template functions. The map
+// can be used as the Templater's Functions or merged into them using Funcs.
+// The following functions are provided:
+//
+//	title        "hello world" -> "Hello World"
+//	upper        "hello" -> "HELLO"
+//	lower        "HELLO" -> "hello"
+//	join         join ", " .Tags -> "a, b, c"
+//	default      default "none" .Value -> .Value, or "none" if .Value is empty
+//	dict         dict "a" 1 "b" 2 -> map[string]interface{}{"a": 1, "b": 2}
+//	seq          seq 3 -> []int{0, 1, 2}
+//	truncate     truncate 5 "hello world" -> "hello"
+//	safeHTML     safeHTML "<b>hi</b>" -> template.HTML("<b>hi</b>")
+//	jsEscape     jsEscape "it's" -> "it\'s"
+//	cssEscape    cssEscape "a b" -> "a\20 b"
+//	urlQuery     urlQuery "a b&c" -> "a+b%26c"
+//	jsonMarshal  jsonMarshal .Data -> template.JS(`{"a":1}`)
+//
+// dict is useful for passing multiple values to a subtemplate, e.g.
+//
+//	{{ template "user" (dict "User" .User "Compact" true) }}
+//
+// safeHTML marks the given string as safe HTML, which bypasses escaping
+// entirely. It must never be used on untrusted input, since doing so opens up
+// XSS vulnerabilities.
+//
+// jsEscape, cssEscape and urlQuery escape a string for use within a JS string,
+// a CSS identifier or string, and a URL query respectively. They return plain
+// strings, which html/template still escapes for the context they end up in,
+// so they're meant for values that are passed on to be interpreted later, e.g.
+// a data attribute that a script builds a selector or URL out of. Used
+// directly within a <script>, <style> or URL, the value is escaped twice.
+//
+// jsonMarshal encodes the value as JSON and marks it as safe JS, e.g. for
+// {{ jsonMarshal .Data }} within a <script>. It's safe to embed, since
+// encoding/json escapes "<", ">", "&", U+2028 and U+2029, but the value must
+// not implement json.Marshaler in a way that returns such characters raw.
+func DefaultFuncs() template.FuncMap {
+	return template.FuncMap{
+		"title":    title,
+		"upper":    strings.ToUpper,
+		"lower":    strings.ToLower,
+		"join":     join,
+		"default":  defaultValue,
+		"dict":     dict,
+		"seq":      seq,
+		"truncate": truncate,
+		"safeHTML": safeHTML,
+
+		"jsEscape":    template.JSEscapeString,
+		"cssEscape":   cssEscape,
+		"urlQuery":    url.QueryEscape,
+		"jsonMarshal": jsonMarshal,
+	}
+}
+
+func title(s string) string {
+	prev := ' '
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(prev) {
+			r = unicode.ToTitle(r)
+		}
+		prev = r
+		return r
+	}, s)
+}
+
+func join(sep string, elems []string) string {
+	return strings.Join(elems, sep)
+}
+
+func defaultValue(def, v interface{}) interface{} {
+	if v == nil {
+		return def
+	}
+
+	if rv := reflect.ValueOf(v); rv.IsZero() {
+		return def
+	}
+
+	return v
+}
+
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("dict: odd number of arguments")
+	}
+
+	m := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		k, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict: key %v is %T, not string", pairs[i], pairs[i])
+		}
+		m[k] = pairs[i+1]
+	}
+
+	return m, nil
+}
+
+func seq(n int) []int {
+	if n <= 0 {
+		return nil
+	}
+
+	s := make([]int, n)
+	for i := range s {
+		s[i] = i
+	}
+	return s
+}
+
+func truncate(n int, s string) string {
+	if n <= 0 {
+		return ""
+	}
+
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+
+	return s
+}
+
+func safeHTML(s string) template.HTML {
+	return template.HTML(s)
+}
+
+// cssEscape escapes every character that isn't a letter or a digit as a CSS
+// escape sequence, which is safe within both identifiers and strings.
+func cssEscape(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+
+	for _, r := range s {
+		if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+			continue
+		}
+		// The trailing space ends the escape sequence, so that a following
+		// hex digit isn't taken as part of it.
+		fmt.Fprintf(&b, "\\%x ", r)
+	}
+
+	return b.String()
+}
+
+func jsonMarshal(v interface{}) (template.JS, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("jsonMarshal: %w", err)
+	}
+	return template.JS(b), nil
+}
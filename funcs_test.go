@@ -0,0 +1,229 @@
+package tmplutil
+
+import (
+	"html/template"
+	"reflect"
+	"testing"
+)
+
+func TestTitle(t *testing.T) {
+	tests := map[string]string{
+		"":             "",
+		"hello world":  "Hello World",
+		"  two  space": "  Two  Space",
+		"élan vital":   "Élan Vital",
+		"already Up":   "Already Up",
+	}
+	for in, out := range tests {
+		if got := title(in); got != out {
+			t.Errorf("title(%q) = %q, expected %q", in, got, out)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	tests := []struct {
+		sep   string
+		elems []string
+		out   string
+	}{
+		{", ", nil, ""},
+		{", ", []string{"a"}, "a"},
+		{", ", []string{"a", "b", "c"}, "a, b, c"},
+		{"", []string{"a", "b"}, "ab"},
+	}
+	for _, test := range tests {
+		if got := join(test.sep, test.elems); got != test.out {
+			t.Errorf("join(%q, %q) = %q, expected %q", test.sep, test.elems, got, test.out)
+		}
+	}
+}
+
+func TestDefaultValue(t *testing.T) {
+	var nilSlice []string
+
+	tests := []struct {
+		v   interface{}
+		out interface{}
+	}{
+		{nil, "none"},
+		{"", "none"},
+		{0, "none"},
+		{false, "none"},
+		{nilSlice, "none"},
+		{"set", "set"},
+		{1, 1},
+		{true, true},
+	}
+	for _, test := range tests {
+		if got := defaultValue("none", test.v); !reflect.DeepEqual(got, test.out) {
+			t.Errorf("default(%#v) = %#v, expected %#v", test.v, got, test.out)
+		}
+	}
+}
+
+func TestDict(t *testing.T) {
+	m, err := dict()
+	if err != nil || len(m) != 0 {
+		t.Errorf("dict() = %v, %v, expected an empty map", m, err)
+	}
+
+	m, err = dict("a", 1, "b", "two")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, map[string]interface{}{"a": 1, "b": "two"}) {
+		t.Errorf("unexpected map %v", m)
+	}
+
+	if _, err := dict("a"); err == nil {
+		t.Error("expected an odd number of arguments to fail")
+	}
+	if _, err := dict(1, "a"); err == nil {
+		t.Error("expected a non-string key to fail")
+	}
+}
+
+func TestSeq(t *testing.T) {
+	tests := map[int][]int{
+		-1: nil,
+		0:  nil,
+		1:  {0},
+		3:  {0, 1, 2},
+	}
+	for n, out := range tests {
+		if got := seq(n); !reflect.DeepEqual(got, out) {
+			t.Errorf("seq(%d) = %v, expected %v", n, got, out)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		n   int
+		s   string
+		out string
+	}{
+		{5, "", ""},
+		{-1, "hello", ""},
+		{0, "hello", ""},
+		{5, "hello", "hello"},
+		{10, "hello", "hello"},
+		{5, "hello world", "hello"},
+		{2, "héllo", "hé"},
+		{1, "日本語", "日"},
+	}
+	for _, test := range tests {
+		if got := truncate(test.n, test.s); got != test.out {
+			t.Errorf("truncate(%d, %q) = %q, expected %q", test.n, test.s, got, test.out)
+		}
+	}
+}
+
+func TestDefaultFuncs(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"page.html": `{{ upper "a" }}{{ lower "B" }} {{ title "x y" }} {{ default "none" .Missing }} ` +
+			`{{ range seq 2 }}{{ . }}{{ end }} {{ with dict "k" "v" }}{{ .k }}{{ end }} {{ safeHTML "<b>" }}`,
+	})
+	tmpler.Funcs(DefaultFuncs())
+
+	out := mustRender(t, tmpler, "page", map[string]interface{}{})
+	if out != "Ab X Y none 01 v <b>" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+func TestSafeHTML(t *testing.T) {
+	if got := safeHTML(""); got != template.HTML("") {
+		t.Errorf("safeHTML(\"\") = %q", got)
+	}
+}
+
+func TestCSSEscape(t *testing.T) {
+	tests := map[string]string{
+		"":                   "",
+		"abc123":             "abc123",
+		"a b":                `a\20 b`,
+		`"};body{color:red}`: `\22 \7d \3b body\7b color\3a red\7d `,
+		"</style>":           `\3c \2f style\3e `,
+		"é":                  `\e9 `,
+	}
+	for in, out := range tests {
+		if got := cssEscape(in); got != out {
+			t.Errorf("cssEscape(%q) = %q, expected %q", in, got, out)
+		}
+	}
+}
+
+func TestJSONMarshal(t *testing.T) {
+	tests := []struct {
+		v   interface{}
+		out template.JS
+	}{
+		{nil, `null`},
+		{map[string]int{"a": 1}, `{"a":1}`},
+		{"</script><script>alert(1)</script>", `"\u003c/script\u003e\u003cscript\u003ealert(1)\u003c/script\u003e"`},
+		{"a & b", `"a \u0026 b"`},
+		{"\u2028", `"\u2028"`},
+	}
+	for _, test := range tests {
+		got, err := jsonMarshal(test.v)
+		if err != nil {
+			t.Errorf("jsonMarshal(%#v) failed: %v", test.v, err)
+			continue
+		}
+		if got != test.out {
+			t.Errorf("jsonMarshal(%#v) = %q, expected %q", test.v, got, test.out)
+		}
+	}
+
+	if _, err := jsonMarshal(func() {}); err == nil {
+		t.Error("expected a function to fail to marshal")
+	}
+}
+
+func TestEscapeFuncs(t *testing.T) {
+	tests := []struct {
+		fn  string
+		in  string
+		out string
+	}{
+		{"jsEscape", `it's`, `it\'s`},
+		{"jsEscape", `"</script>`, `\"\u003C/script\u003E`},
+		{"jsEscape", "\\\n", `\\\u000A`},
+		{"urlQuery", "a b&c", "a+b%26c"},
+		{"urlQuery", `"><script>`, "%22%3E%3Cscript%3E"},
+		{"urlQuery", "../?x=1#y", "..%2F%3Fx%3D1%23y"},
+	}
+	for _, test := range tests {
+		fn := DefaultFuncs()[test.fn].(func(string) string)
+		if got := fn(test.in); got != test.out {
+			t.Errorf("%s(%q) = %q, expected %q", test.fn, test.in, got, test.out)
+		}
+	}
+}
+
+func TestFuncsDuplicate(t *testing.T) {
+	tmpler := NewTemplater(nil)
+	tmpler.Logger = discardLogger
+	tmpler.Func("b", func() string { return "b" })
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a duplicate function to panic")
+			}
+		}()
+
+		tmpler.Funcs(template.FuncMap{
+			"a": func() string { return "a" },
+			"b": func() string { return "other b" },
+			"c": func() string { return "c" },
+		})
+	}()
+
+	// Nothing is registered if any function is a duplicate.
+	if len(tmpler.Functions) != 1 {
+		t.Errorf("expected only the first function, got %v", tmpler.Functions)
+	}
+}
@@ -1,5 +1,23 @@
 module libdb.so/tmplutil
 
-go 1.16
+go 1.21
 
-require github.com/phogolabs/parcello v0.8.2
+require (
+	github.com/Masterminds/sprig/v3 v3.2.3
+	github.com/fsnotify/fsnotify v1.5.4
+	github.com/phogolabs/parcello v0.8.2
+)
+
+require (
+	github.com/Masterminds/goutils v1.1.1 // indirect
+	github.com/Masterminds/semver/v3 v3.2.0 // indirect
+	github.com/google/uuid v1.1.1 // indirect
+	github.com/huandu/xstrings v1.3.3 // indirect
+	github.com/imdario/mergo v0.3.11 // indirect
+	github.com/mitchellh/copystructure v1.0.0 // indirect
+	github.com/mitchellh/reflectwalk v1.0.0 // indirect
+	github.com/shopspring/decimal v1.2.0 // indirect
+	github.com/spf13/cast v1.3.1 // indirect
+	golang.org/x/crypto v0.3.0 // indirect
+	golang.org/x/sys v0.2.0 // indirect
+)
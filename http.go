@@ -0,0 +1,498 @@
+package tmplutil
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DataFunc is a function that returns the data to render a subtemplate with for
+// the given request.
+type DataFunc func(r *http.Request) (interface{}, error)
+
+// Handler creates an HTTP handler that renders the subtemplate with the data
+// returned by the given function. If the function returns an error, then a 500
+// is written and the subtemplate is not rendered. Render failures are routed
+// through OnRenderFail. The Content-Type is set using ContentType.
+func (sub *Subtemplate) Handler(data DataFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v, err := data(r)
+		if err != nil {
+			if sub.tmpl.debug() {
+				sub.tmpl.logf(slog.LevelError, "failed to get data for %q: %v", sub.name, err)
+			}
+
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		sub.setContentType(w.Header())
+		sub.ExecuteContext(r.Context(), w, v)
+	})
+}
+
+// ExecuteHTTP executes the subtemplate into the response with the given status
+// code, e.g. for a 404 page. The Content-Type is set like Handler does. The
+// output is buffered, so that the status is only written once rendering has
+// succeeded. If it fails, then the status is 500 Internal Server Error instead,
+// and OnRenderFail may write an error page as the body.
+func (sub *Subtemplate) ExecuteHTTP(w http.ResponseWriter, status int, v interface{}) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	sub.setContentType(w.Header())
+
+	if err := sub.tmpl.execute(buf, sub.name, v, execOptions{}); err != nil {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusInternalServerError}
+		sub.tmpl.onRenderFail(sw, sub.name, err)
+		// Write the status in case OnRenderFail wrote nothing.
+		sw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+
+	w.WriteHeader(status)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// ServeContent renders the subtemplate into a buffer and serves it using
+// http.ServeContent, which handles Range, If-Modified-Since and similar
+// requests with modtime as the last modification time, e.g. for large
+// generated documents. The Content-Type is set like Handler does. Render
+// failures are routed through OnRenderFail and nothing else is written.
+func (sub *Subtemplate) ServeContent(w http.ResponseWriter, r *http.Request, modtime time.Time, v interface{}) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	ctx := r.Context()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := sub.tmpl.execute(buf, sub.name, v, execOptions{ctx: ctx}); err != nil {
+		if ctx.Err() == nil {
+			sub.tmpl.onRenderFail(w, sub.name, err)
+		}
+		return err
+	}
+
+	sub.setContentType(w.Header())
+	http.ServeContent(w, r, sub.name, modtime, bytes.NewReader(buf.Bytes()))
+	return nil
+}
+
+func (sub *Subtemplate) setContentType(h http.Header) {
+	h.Set("Content-Type", sub.tmpl.ContentType(sub.name))
+}
+
+// ContentType returns the MIME type of the rendered output of the include with
+// the given name, which is looked up by its file extension. Includes with a
+// PostProcessor for ".md" are rendered into HTML, so they're HTML as well.
+// Includes with an unknown extension are HTML, or plain text in TextMode.
+func (tmpler *Templater) ContentType(name string) string {
+	path, _ := tmpler.Lookup(name)
+	return tmpler.contentType(path)
+}
+
+// contentType returns the MIME type of the rendered output of the include with
+// the given path. Refer to ContentType.
+func (tmpler *Templater) contentType(path string) string {
+	ext := filepath.Ext(path)
+
+	if _, ok := tmpler.PostProcessors[ext]; ok && ext == ".md" {
+		return "text/html; charset=utf-8"
+	}
+
+	if typ := mime.TypeByExtension(ext); typ != "" {
+		return typ
+	}
+
+	if tmpler.TextMode {
+		return "text/plain; charset=utf-8"
+	}
+	return "text/html; charset=utf-8"
+}
+
+// statusWriter writes the status code along with the first write, unless
+// another status code is written first.
+type statusWriter struct {
+	http.ResponseWriter
+	status    int
+	wroteHead bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.wroteHead {
+		return
+	}
+	w.wroteHead = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(w.status)
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher.
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.WriteHeader(w.status)
+		flusher.Flush()
+	}
+}
+
+// NegotiatedHandler creates an HTTP handler like Handler, except that if the
+// subtemplate is a ".md" include and the request's Accept header prefers
+// "text/markdown" over "text/html", then the templated markdown is written
+// as-is without going through PostProcessors. Otherwise, including when the
+// Accept header is "*/*", the output is rendered as usual.
+func (sub *Subtemplate) NegotiatedHandler(data func(r *http.Request) interface{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := data(r)
+		w.Header().Add("Vary", "Accept")
+
+		path, _ := sub.tmpl.Lookup(sub.name)
+		if filepath.Ext(path) == ".md" && prefersMarkdown(r.Header.Get("Accept")) {
+			w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+			sub.tmpl.executeContext(w, sub.name, v, execOptions{ctx: r.Context(), raw: true})
+			return
+		}
+
+		sub.setContentType(w.Header())
+		sub.ExecuteContext(r.Context(), w, v)
+	})
+}
+
+// prefersMarkdown returns true if the given Accept header prefers markdown over
+// HTML. Ties go to HTML.
+func prefersMarkdown(accept string) bool {
+	return acceptQuality(accept, "text", "markdown") > acceptQuality(accept, "text", "html")
+}
+
+// acceptQuality returns the quality value of the given media type in the Accept
+// header, using the most specific media range that matches it.
+func acceptQuality(accept, typ, subtype string) float64 {
+	q := 0.0
+	specificity := -1
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+
+		var s int
+		switch mediaType {
+		case typ + "/" + subtype:
+			s = 2
+		case typ + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		default:
+			continue
+		}
+
+		if s <= specificity {
+			continue
+		}
+
+		specificity = s
+		q = 1
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+	}
+
+	return q
+}
+
+// VersionMiddleware is the middleware that sets the X-Template-Version header
+// of every response to the Version of the templates.
+func (tmpler *Templater) VersionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Template-Version", tmpler.Version())
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ETagBufferLimit is the maximum size of a response that ETagMiddleware will
+// buffer. Responses larger than this are streamed without an ETag.
+var ETagBufferLimit = 1 << 20 // 1MB
+
+// ReloadHandler returns an HTTP handler that calls Reload, e.g. to be mounted
+// on an admin endpoint. It responds with 500 and the error if reloading fails.
+// The handler should be protected, since it exposes the error.
+func (tmpler *Templater) ReloadHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := tmpler.Reload(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// ETagMiddleware is the middleware that buffers successful GET and HEAD
+// responses to compute their ETag, replying with 304 Not Modified if the ETag
+// matches the request's If-None-Match header. Responses larger than
+// ETagBufferLimit fall back to being streamed without an ETag.
+//
+// Since the response is buffered, wrapping AlwaysFlush inside this middleware
+// has no effect.
+func ETagMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ew := &etagWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(ew, r)
+		ew.finish(r)
+	})
+}
+
+type etagWriter struct {
+	http.ResponseWriter
+	buf       bytes.Buffer
+	status    int
+	wroteHead bool
+	streaming bool
+}
+
+func (w *etagWriter) WriteHeader(status int) {
+	if w.wroteHead {
+		return
+	}
+	w.wroteHead = true
+	w.status = status
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	if w.streaming {
+		return w.ResponseWriter.Write(b)
+	}
+
+	if w.buf.Len()+len(b) > ETagBufferLimit {
+		if err := w.stream(); err != nil {
+			return 0, err
+		}
+		return w.ResponseWriter.Write(b)
+	}
+
+	return w.buf.Write(b)
+}
+
+// stream writes out everything buffered so far and switches to writing
+// directly to the underlying writer.
+func (w *etagWriter) stream() error {
+	w.streaming = true
+	w.ResponseWriter.WriteHeader(w.status)
+
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf = bytes.Buffer{}
+	return err
+}
+
+func (w *etagWriter) finish(r *http.Request) {
+	if w.streaming {
+		return
+	}
+
+	if w.status != http.StatusOK {
+		w.stream()
+		return
+	}
+
+	sum := sha256.Sum256(w.buf.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	h := w.Header()
+	h.Set("ETag", etag)
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		w.ResponseWriter.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	h.Set("Content-Length", strconv.Itoa(w.buf.Len()))
+	w.stream()
+}
+
+// etagMatches returns true if the If-None-Match header matches the ETag.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		tag = strings.TrimPrefix(tag, "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Compress is the middleware that gzip-compresses responses if the request's
+// Accept-Encoding header allows it. Responses whose Content-Type is already
+// compressed, such as images and archives, and responses that already have a
+// Content-Encoding are written as-is.
+//
+// Compress may wrap AlwaysFlush, in which case every flush also flushes the
+// compressor, so that the client receives each write as soon as possible.
+func Compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if r.Method == http.MethodHead || !acceptsEncoding(r.Header.Get("Accept-Encoding"), "gzip") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w}
+		defer cw.close()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptsEncoding returns true if the given Accept-Encoding header allows the
+// given content coding.
+func acceptsEncoding(acceptEncoding, coding string) bool {
+	q := 0.0
+	specific := false
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.TrimSpace(name)
+
+		switch {
+		case strings.EqualFold(name, coding):
+			specific = true
+		case name == "*" && !specific:
+		default:
+			continue
+		}
+
+		q = 1
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+	}
+
+	return q > 0
+}
+
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(nil) },
+}
+
+type compressWriter struct {
+	http.ResponseWriter
+	gz        *gzip.Writer
+	wroteHead bool
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHead {
+		return
+	}
+	w.wroteHead = true
+
+	h := w.Header()
+	if status >= http.StatusOK &&
+		status != http.StatusNoContent &&
+		status != http.StatusNotModified &&
+		h.Get("Content-Encoding") == "" &&
+		!isCompressed(h.Get("Content-Type")) {
+
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+
+		w.gz = gzipWriterPool.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.wroteHead {
+		// The Content-Type must be sniffed from the uncompressed content.
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.gz == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.gz.Write(b)
+}
+
+// Flush implements http.Flusher.
+func (w *compressWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *compressWriter) close() {
+	if w.gz == nil {
+		return
+	}
+
+	w.gz.Close()
+	w.gz.Reset(nil)
+	gzipWriterPool.Put(w.gz)
+	w.gz = nil
+}
+
+// isCompressed returns true if content of the given type is already compressed
+// and wouldn't benefit from being compressed again.
+func isCompressed(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch mediaType {
+	case "image/svg+xml":
+		return false
+	case
+		"application/gzip",
+		"application/x-gzip",
+		"application/zip",
+		"application/zstd",
+		"application/pdf",
+		"font/woff",
+		"font/woff2":
+		return true
+	}
+
+	typ, _, _ := strings.Cut(mediaType, "/")
+	return typ == "image" || typ == "audio" || typ == "video"
+}
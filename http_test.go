@@ -0,0 +1,290 @@
+package tmplutil
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestExecuteHTTP(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"404.html": `<p>{{ . }} not found</p>`,
+	})
+
+	rec := httptest.NewRecorder()
+	if err := tmpler.Subtemplate("404").ExecuteHTTP(rec, http.StatusNotFound, "/missing"); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", rec.Code)
+	}
+	if body := rec.Body.String(); body != "<p>/missing not found</p>" {
+		t.Errorf("unexpected body %q", body)
+	}
+	if typ := rec.Header().Get("Content-Type"); typ != "text/html; charset=utf-8" {
+		t.Errorf("unexpected Content-Type %q", typ)
+	}
+}
+
+func TestExecuteHTTPFailure(t *testing.T) {
+	tests := []struct {
+		name         string
+		onRenderFail RenderFailFunc
+		body         string
+	}{
+		{
+			name: "error page",
+			onRenderFail: func(sub *Subtemplate, w io.Writer, err error) {
+				io.WriteString(w, "error page")
+			},
+			body: "error page",
+		},
+		{
+			name: "no error page",
+			body: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpler := newTestTemplater(t, map[string]string{
+				"page.html": `partial {{ fail }}`,
+			})
+			tmpler.Functions["fail"] = func() (string, error) {
+				return "", errors.New("failed")
+			}
+			tmpler.BufferRenders = true
+			tmpler.OnRenderFail = test.onRenderFail
+
+			rec := httptest.NewRecorder()
+			if err := tmpler.Subtemplate("page").ExecuteHTTP(rec, http.StatusOK, nil); err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if rec.Code != http.StatusInternalServerError {
+				t.Errorf("expected status 500, got %d", rec.Code)
+			}
+			if body := rec.Body.String(); body != test.body {
+				t.Errorf("expected body %q, got %q", test.body, body)
+			}
+		})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"list.html": `<ul>{{ range . }}<li>{{ . }}</li>{{ end }}</ul>`,
+	})
+
+	items := make([]int, 10000)
+	for i := range items {
+		items[i] = i
+	}
+
+	var expect strings.Builder
+	mustExecute := func(w io.Writer) {
+		if err := tmpler.Execute(w, "list", items); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustExecute(&expect)
+
+	handler := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		mustExecute(w)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if enc := rec.Header().Get("Content-Encoding"); enc != "gzip" {
+		t.Fatalf("expected gzip encoding, got %q", enc)
+	}
+	if rec.Body.Len() >= expect.Len() {
+		t.Errorf("expected the body to shrink, got %d >= %d bytes", rec.Body.Len(), expect.Len())
+	}
+
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != expect.String() {
+		t.Error("decompressed body differs from the rendered template")
+	}
+}
+
+func TestContentType(t *testing.T) {
+	tmpler := NewTemplater(mapFS(map[string]string{}))
+	tmpler.PostProcessors = map[string]PostProcessor{".md": upperMarkdown}
+
+	tests := []struct {
+		path     string
+		textMode bool
+		expect   string
+	}{
+		{path: "page.html", expect: "text/html; charset=utf-8"},
+		{path: "post.md", expect: "text/html; charset=utf-8"},
+		{path: "style.css", expect: "text/css; charset=utf-8"},
+		{path: "feed.xml", expect: "text/xml; charset=utf-8"},
+		{path: "page.tmpl", expect: "text/html; charset=utf-8"},
+		{path: "page.tmpl", textMode: true, expect: "text/plain; charset=utf-8"},
+	}
+
+	for _, test := range tests {
+		tmpler.TextMode = test.textMode
+		if typ := tmpler.contentType(test.path); typ != test.expect {
+			t.Errorf("%s (TextMode=%v): expected %q, got %q", test.path, test.textMode, test.expect, typ)
+		}
+	}
+}
+
+func TestServeContentRange(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"doc.txt": `{{ . }}`,
+	})
+
+	req := httptest.NewRequest("GET", "/doc.txt", nil)
+	req.Header.Set("Range", "bytes=4-8")
+
+	rec := httptest.NewRecorder()
+	err := tmpler.Subtemplate("doc").ServeContent(rec, req, time.Unix(1700000000, 0), "0123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.Code != http.StatusPartialContent {
+		t.Errorf("expected status 206, got %d", rec.Code)
+	}
+	if body := rec.Body.String(); body != "45678" {
+		t.Errorf("expected the requested range, got %q", body)
+	}
+	if cr := rec.Header().Get("Content-Range"); cr != "bytes 4-8/10" {
+		t.Errorf("unexpected Content-Range %q", cr)
+	}
+	if typ := rec.Header().Get("Content-Type"); typ != "text/plain; charset=utf-8" {
+		t.Errorf("unexpected Content-Type %q", typ)
+	}
+}
+
+func TestReloadHandler(t *testing.T) {
+	fsys := mapFS(map[string]string{
+		"page.html": `old`,
+	})
+	tmpler := NewTemplater(fsys)
+	tmpler.Register("page", "page.html")
+	tmpler.Preload()
+
+	handler := tmpler.ReloadHandler()
+
+	tests := []struct {
+		method string
+		src    string
+		status int
+		out    string
+	}{
+		{method: "GET", src: `new`, status: http.StatusMethodNotAllowed, out: "old"},
+		{method: "POST", src: `{{ if }}`, status: http.StatusInternalServerError, out: "old"},
+		{method: "POST", src: `new`, status: http.StatusNoContent, out: "new"},
+	}
+
+	for _, test := range tests {
+		fsys["page.html"] = &fstest.MapFile{Data: []byte(test.src)}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(test.method, "/reload", nil))
+
+		if rec.Code != test.status {
+			t.Errorf("%s %q: expected status %d, got %d", test.method, test.src, test.status, rec.Code)
+		}
+		if out := mustRender(t, tmpler, "page", nil); out != test.out {
+			t.Errorf("%s %q: expected %q, got %q", test.method, test.src, test.out, out)
+		}
+	}
+}
+
+func TestETagMiddleware(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"page.html": `<p>{{ . }}</p>`,
+	})
+
+	handler := ETagMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tmpler.Subtemplate("page").ExecuteHTTP(w, http.StatusOK, "hello")
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected a 200 with an ETag, got %d and %q", rec.Code, etag)
+	}
+	if body := rec.Body.String(); body != "<p>hello</p>" {
+		t.Errorf("unexpected body %q", body)
+	}
+	if length := rec.Header().Get("Content-Length"); length != "12" {
+		t.Errorf("unexpected Content-Length %q", length)
+	}
+
+	for _, ifNoneMatch := range []string{etag, `"other", W/` + etag, "*"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("If-None-Match", ifNoneMatch)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusNotModified {
+			t.Errorf("If-None-Match %q: expected status 304, got %d", ifNoneMatch, rec.Code)
+		}
+		if rec.Body.Len() != 0 {
+			t.Errorf("If-None-Match %q: expected no body, got %q", ifNoneMatch, rec.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("If-None-Match", `"other"`)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "<p>hello</p>" {
+		t.Errorf("expected a mismatching ETag to get the page, got %d and %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestETagMiddlewareLimit(t *testing.T) {
+	defer func(limit int) { ETagBufferLimit = limit }(ETagBufferLimit)
+	ETagBufferLimit = 16
+
+	body := strings.Repeat("x", 40)
+
+	handler := ETagMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body[:10])
+		io.WriteString(w, body[10:])
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if rec.Code != http.StatusOK || rec.Body.String() != body {
+		t.Errorf("expected the whole body to be streamed, got %d and %q", rec.Code, rec.Body.String())
+	}
+	if etag := rec.Header().Get("ETag"); etag != "" {
+		t.Errorf("expected no ETag above the limit, got %q", etag)
+	}
+}
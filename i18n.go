@@ -0,0 +1,111 @@
+package tmplutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"strings"
+)
+
+type localeKey struct{}
+
+// WithLocale returns a copy of the context with the given locale, which the "t"
+// function translates messages into.
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey{}, locale)
+}
+
+// LocaleFromContext returns the locale set by WithLocale. It returns an empty
+// string if there's none.
+func LocaleFromContext(ctx context.Context) string {
+	locale, _ := ctx.Value(localeKey{}).(string)
+	return locale
+}
+
+// LoadTranslations loads the message catalogs from the JSON files in the given
+// directory of the FileSystem into Translations. Each file is named after its
+// locale, e.g. "fr.json" or "fr-CA.json", and contains an object mapping keys
+// to messages:
+//
+//	{"greeting": "Bonjour, %s!"}
+//
+// Messages from later calls replace earlier ones with the same key. It should
+// only be called before preloading.
+func (tmpler *Templater) LoadTranslations(dir string) error {
+	entries, err := fs.ReadDir(tmpler.FileSystem, dir)
+	if err != nil {
+		return fmt.Errorf("failed to read dir: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		b, err := fs.ReadFile(tmpler.FileSystem, path.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read %q: %w", entry.Name(), err)
+		}
+
+		var messages map[string]string
+		if err := json.Unmarshal(b, &messages); err != nil {
+			return fmt.Errorf("failed to decode %q: %w", entry.Name(), err)
+		}
+
+		if tmpler.Translations == nil {
+			tmpler.Translations = make(map[string]map[string]string)
+		}
+
+		locale := strings.TrimSuffix(entry.Name(), ".json")
+		if tmpler.Translations[locale] == nil {
+			tmpler.Translations[locale] = make(map[string]string, len(messages))
+		}
+
+		for key, msg := range messages {
+			tmpler.Translations[locale][key] = msg
+		}
+	}
+
+	return nil
+}
+
+// translator returns the "t" function for the locale in the given context.
+func (tmpler *Templater) translator(translations map[string]map[string]string, ctx context.Context) func(key string, args ...interface{}) string {
+	locale := LocaleFromContext(ctx)
+
+	return func(key string, args ...interface{}) string {
+		msg, ok := translate(translations, locale, key)
+		if !ok {
+			if tmpler.debug() {
+				tmpler.logf(slog.LevelWarn, "missing translation for %q in locale %q", key, locale)
+			}
+			return key
+		}
+
+		if len(args) == 0 {
+			return msg
+		}
+		return fmt.Sprintf(msg, args...)
+	}
+}
+
+// translate looks up the message for the given key, falling back from locales
+// with a region, e.g. "fr-CA", to the language, e.g. "fr".
+func translate(translations map[string]map[string]string, locale, key string) (string, bool) {
+	for locale != "" {
+		if msg, ok := translations[locale][key]; ok {
+			return msg, true
+		}
+
+		i := strings.LastIndexAny(locale, "-_")
+		if i == -1 {
+			break
+		}
+		locale = locale[:i]
+	}
+
+	return "", false
+}
@@ -0,0 +1,59 @@
+package tmplutil
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestTranslations(t *testing.T) {
+	tmpler := NewTemplater(mapFS(map[string]string{
+		"page.html":          `{{ t "greeting" . }} {{ t "bye" }} {{ t "missing" }}`,
+		"locales/en.json":    `{"greeting": "Hello, %s!", "bye": "Bye"}`,
+		"locales/fr.json":    `{"greeting": "Bonjour, %s !", "bye": "Au revoir"}`,
+		"locales/fr-CA.json": `{"bye": "Salut"}`,
+		"locales/README.txt": `not a catalog`,
+	}))
+	tmpler.Register("page", "page.html")
+
+	if err := tmpler.LoadTranslations("locales"); err != nil {
+		t.Fatal(err)
+	}
+	if len(tmpler.Translations) != 3 {
+		t.Errorf("expected 3 locales, got %v", tmpler.Translations)
+	}
+
+	tests := map[string]string{
+		"en":    "Hello, alice! Bye missing",
+		"en-GB": "Hello, alice! Bye missing",
+		"fr":    "Bonjour, alice ! Au revoir missing",
+		"fr-CA": "Bonjour, alice ! Salut missing",
+		"de":    "greeting bye missing",
+		"":      "greeting bye missing",
+	}
+
+	for locale, expect := range tests {
+		var buf strings.Builder
+		ctx := WithLocale(context.Background(), locale)
+		if err := tmpler.ExecuteContext(ctx, &buf, "page", "alice"); err != nil {
+			t.Errorf("%q: %v", locale, err)
+			continue
+		}
+		if buf.String() != expect {
+			t.Errorf("%q: expected %q, got %q", locale, expect, buf.String())
+		}
+	}
+}
+
+func TestLoadTranslationsErrors(t *testing.T) {
+	tmpler := NewTemplater(mapFS(map[string]string{
+		"locales/en.json": `{"greeting": 1}`,
+	}))
+
+	if err := tmpler.LoadTranslations("locales"); err == nil || !strings.Contains(err.Error(), "en.json") {
+		t.Errorf("expected the invalid catalog to fail, got %v", err)
+	}
+	if err := tmpler.LoadTranslations("missing"); err == nil {
+		t.Error("expected a missing directory to fail")
+	}
+}
@@ -0,0 +1,168 @@
+package tmplutil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// ErrPrecompiledStale is returned by LoadPrecompiled if any of the precompiled
+// includes has changed in the FileSystem since it was precompiled.
+var ErrPrecompiledStale = errors.New("precompiled templates are stale")
+
+// precompiled is the file written by Precompile.
+type precompiled struct {
+	Includes []precompiledInclude `json:"includes"`
+}
+
+type precompiledInclude struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Layout  string    `json:"layout,omitempty"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Source  string    `json:"source"`
+}
+
+// Precompile parses all registered templates and writes their sources along
+// with a manifest of the includes into a single file at the given path, which
+// can later be loaded using LoadPrecompiled. An error is returned if any of the
+// templates fail to parse.
+func (tmpler *Templater) Precompile(path string) error {
+	tmpler.tmplMu.Lock()
+	defer tmpler.tmplMu.Unlock()
+
+	var pc precompiled
+	sources := make(map[string]string, len(tmpler.Includes))
+
+	for name, incl := range tmpler.Includes {
+		// Includes registered by RegisterString have no file and are
+		// registered again by the code anyway.
+		if src, ok := tmpler.strSrcs[name]; ok {
+			sources[name] = src
+			continue
+		}
+
+		b, err := fs.ReadFile(tmpler.FileSystem, incl)
+		if err != nil {
+			return fmt.Errorf("failed to read %q: %w", incl, err)
+		}
+
+		stat, err := fs.Stat(tmpler.FileSystem, incl)
+		if err != nil {
+			return fmt.Errorf("failed to stat %q: %w", incl, err)
+		}
+
+		pcIncl := precompiledInclude{
+			Name:    name,
+			Path:    incl,
+			Layout:  tmpler.layouts[name],
+			Size:    stat.Size(),
+			ModTime: stat.ModTime(),
+			Source:  string(b),
+		}
+
+		pc.Includes = append(pc.Includes, pcIncl)
+		sources[name] = pcIncl.Source
+	}
+
+	read := func(name string) (string, error) { return sources[name], nil }
+	if _, err := tmpler.parseSources(read); err != nil {
+		return fmt.Errorf("failed to parse: %w", err)
+	}
+
+	b, err := json.Marshal(pc)
+	if err != nil {
+		return fmt.Errorf("failed to encode: %w", err)
+	}
+
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		return fmt.Errorf("failed to write: %w", err)
+	}
+
+	return nil
+}
+
+// LoadPrecompiled loads the templates from a file written by Precompile. The
+// includes in the file are registered, and the templates are parsed from the
+// sources in the file instead of the FileSystem, so calling Preregister is not
+// needed. This makes cold starts faster for apps with many includes, especially
+// when the FileSystem is slow to walk or read.
+//
+// If any of the includes has changed in the FileSystem since it was
+// precompiled, then ErrPrecompiledStale is returned, and the caller should fall
+// back to registering the templates normally. Changes are detected by the size
+// and modification time of each file, so that no file has to be read. Files
+// without modification times, such as those of embed.FS, are only compared by
+// size, since their contents cannot change without rebuilding the program; the
+// precompiled file should then be generated along with the build.
+func (tmpler *Templater) LoadPrecompiled(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read: %w", err)
+	}
+
+	var pc precompiled
+	if err := json.Unmarshal(b, &pc); err != nil {
+		return fmt.Errorf("failed to decode: %w", err)
+	}
+
+	tmpler.tmplMu.Lock()
+	defer tmpler.tmplMu.Unlock()
+
+	sources := make(map[string]string, len(pc.Includes))
+
+	for _, incl := range pc.Includes {
+		if err := incl.checkStale(tmpler.FileSystem); err != nil {
+			return err
+		}
+
+		sources[incl.Name] = incl.Source
+	}
+
+	if tmpler.Includes == nil {
+		tmpler.Includes = map[string]string{}
+	}
+
+	for _, incl := range pc.Includes {
+		tmpler.Includes[incl.Name] = incl.Path
+
+		if incl.Layout != "" {
+			if tmpler.layouts == nil {
+				tmpler.layouts = make(map[string]string)
+			}
+			tmpler.layouts[incl.Name] = incl.Layout
+		}
+	}
+
+	// Includes that were registered but not precompiled are still read from
+	// the FileSystem, or taken from RegisterString.
+	t, err := tmpler.parseSources(func(name string) (string, error) {
+		if src, ok := sources[name]; ok {
+			return src, nil
+		}
+		return tmpler.readSource(name)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to parse: %w", err)
+	}
+
+	tmpler.tmpl.Store(t)
+	return nil
+}
+
+func (incl precompiledInclude) checkStale(fsys fs.FS) error {
+	stat, err := fs.Stat(fsys, incl.Path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPrecompiledStale, err)
+	}
+
+	if stat.Size() != incl.Size || !stat.ModTime().Equal(incl.ModTime) {
+		return fmt.Errorf("%w: %q changed", ErrPrecompiledStale, incl.Path)
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package tmplutil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestPrecompile(t *testing.T) {
+	fsys := fstest.MapFS{
+		"page.html":   {Data: []byte(`<main>{{ template "nav" . }}</main>`), ModTime: time.Unix(1700000000, 0)},
+		"nav.html":    {Data: []byte(`<nav>{{ . }}</nav>`), ModTime: time.Unix(1700000000, 0)},
+		"unused.html": {Data: []byte(`unused`)},
+	}
+
+	tmpler := NewTemplater(fsys)
+	tmpler.Register("page", "page.html")
+	tmpler.Register("nav", "nav.html")
+
+	path := filepath.Join(t.TempDir(), "templates.json")
+	if err := tmpler.Precompile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is registered, so the includes can only come from the file.
+	loaded := NewTemplater(fsys)
+	if err := loaded.LoadPrecompiled(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if out := mustRender(t, loaded, "page", "home"); out != "<main><nav>home</nav></main>" {
+		t.Errorf("unexpected output %q", out)
+	}
+	if loaded.Has("unused") {
+		t.Error("expected only the precompiled includes to be registered")
+	}
+}
+
+func TestPrecompileStale(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(fstest.MapFS)
+	}{
+		{"modified", func(fsys fstest.MapFS) {
+			fsys["page.html"].ModTime = time.Unix(1800000000, 0)
+		}},
+		{"resized", func(fsys fstest.MapFS) {
+			fsys["page.html"].Data = []byte(`page changed`)
+		}},
+		{"removed", func(fsys fstest.MapFS) {
+			delete(fsys, "page.html")
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fsys := fstest.MapFS{
+				"page.html": {Data: []byte(`page`), ModTime: time.Unix(1700000000, 0)},
+			}
+
+			tmpler := NewTemplater(fsys)
+			tmpler.Register("page", "page.html")
+
+			path := filepath.Join(t.TempDir(), "templates.json")
+			if err := tmpler.Precompile(path); err != nil {
+				t.Fatal(err)
+			}
+
+			test.change(fsys)
+
+			err := NewTemplater(fsys).LoadPrecompiled(path)
+			if !errors.Is(err, ErrPrecompiledStale) {
+				t.Errorf("expected ErrPrecompiledStale, got %v", err)
+			}
+		})
+	}
+}
+
+func BenchmarkLoadPrecompiled(b *testing.B) {
+	dir := b.TempDir()
+	for i := 0; i < 200; i++ {
+		src := fmt.Sprintf(`<p>{{ . }} %d</p>{{ template "partial" . }}`, i)
+		if i == 0 {
+			src = `<footer>{{ . }}</footer>`
+		}
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("page%d.html", i)), []byte(src), 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	// page0 is the partial of every other page.
+	preregister := func(b *testing.B) *Templater {
+		tmpler := NewTemplater(os.DirFS(dir))
+		if err := tmpler.PreregisterFunc(func(path string) (string, bool) {
+			if path == "page0.html" {
+				return "partial", true
+			}
+			return path, true
+		}); err != nil {
+			b.Fatal(err)
+		}
+		return tmpler
+	}
+
+	path := filepath.Join(b.TempDir(), "templates.json")
+	if err := preregister(b).Precompile(path); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("Preregister", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := preregister(b).Ready(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("LoadPrecompiled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := NewTemplater(os.DirFS(dir)).LoadPrecompiled(path); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
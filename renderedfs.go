@@ -0,0 +1,270 @@
+package tmplutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RenderedFS returns a filesystem of the rendered output of every registered
+// include, found at the same path as the include's file. Includes whose file
+// extension has a PostProcessor are found with the extension replaced by
+// ".html" instead, e.g. "blog/post.html" for "blog/post.md", like Export names
+// them. Each file is rendered when it's opened, with the data returned by the
+// given function for the name of the include. Directories list the includes
+// within them.
+//
+// This allows serving rendered templates using http.FileServer, or exporting
+// them as a static site by walking the filesystem. Render failures are returned
+// when opening the file and are not routed through OnRenderFail.
+//
+// Includes named "index" by their file, e.g. "blog/index.md", are also found
+// at "index.html" in their directory, which http.FileServer serves for
+// requests to the directory, e.g. "/blog/". Opening an empty name or a name
+// with a trailing slash, e.g. "blog/", opens that index file as well.
+func (tmpler *Templater) RenderedFS(data func(name string) interface{}) fs.FS {
+	return tmpler.RenderedFSWithIndex(data, "index")
+}
+
+// RenderedFSWithIndex is like RenderedFS, except the files of index includes
+// are named after the given name instead of "index", e.g. "README" for
+// "README.md".
+func (tmpler *Templater) RenderedFSWithIndex(data func(name string) interface{}, index string) fs.FS {
+	return renderedFS{tmpler, data, index}
+}
+
+// Export renders every registered include into "<dir>/<name>.html", with the
+// data returned by the given function for the name of the include, creating
+// directories as needed, e.g. for names given by PreregisterPaths. This turns
+// the templates into a static site. Includes that fail to render, or whose
+// names would lead out of dir, e.g. "../index", are skipped and their errors
+// are returned joined together.
+func (tmpler *Templater) Export(dir string, data func(name string) interface{}) error {
+	var errs []error
+	var buf bytes.Buffer
+
+	for _, name := range tmpler.RegisteredNames() {
+		// The name is joined onto dir, so it must not lead out of it.
+		if !fs.ValidPath(name) || !filepath.IsLocal(filepath.FromSlash(name)) {
+			errs = append(errs, fmt.Errorf("failed to export %q: invalid name", name))
+			continue
+		}
+
+		buf.Reset()
+
+		if err := tmpler.execute(&buf, name, data(name), execOptions{}); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		dst := filepath.Join(dir, filepath.FromSlash(name)+".html")
+
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			errs = append(errs, fmt.Errorf("failed to export %q: %w", name, err))
+			continue
+		}
+
+		if err := os.WriteFile(dst, buf.Bytes(), 0644); err != nil {
+			errs = append(errs, fmt.Errorf("failed to export %q: %w", name, err))
+			continue
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+type renderedFS struct {
+	tmpler *Templater
+	data   func(name string) interface{}
+	index  string
+}
+
+// paths returns the cleaned paths of the rendered output of all includes
+// mapped to their names, along with the "index.html" path of every index
+// include.
+func (rfs renderedFS) paths() map[string]string {
+	rfs.tmpler.tmplMu.Lock()
+	defer rfs.tmpler.tmplMu.Unlock()
+
+	paths := make(map[string]string, len(rfs.tmpler.Includes))
+	indexes := make(map[string]string)
+
+	for name, incl := range rfs.tmpler.Includes {
+		if _, ok := rfs.tmpler.strSrcs[name]; ok {
+			continue
+		}
+
+		incl = path.Clean(incl)
+
+		// Post-processed includes are rendered into HTML.
+		if ext := path.Ext(incl); ext != "" {
+			if _, ok := rfs.tmpler.PostProcessors[ext]; ok {
+				incl = strings.TrimSuffix(incl, ext) + ".html"
+			}
+		}
+
+		paths[incl] = name
+
+		base := path.Base(incl)
+		if strings.TrimSuffix(base, path.Ext(base)) == rfs.index {
+			indexes[path.Join(path.Dir(incl), "index.html")] = name
+		}
+	}
+
+	// Actual files take precedence over the index paths.
+	for p, name := range indexes {
+		if _, ok := paths[p]; !ok {
+			paths[p] = name
+		}
+	}
+
+	return paths
+}
+
+func (rfs renderedFS) Open(name string) (fs.File, error) {
+	// Resolve directory-style names to their index file.
+	if name == "" {
+		name = "index.html"
+	} else if strings.HasSuffix(name, "/") {
+		name += "index.html"
+	}
+
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	paths := rfs.paths()
+
+	if tmpl, ok := paths[name]; ok {
+		var buf bytes.Buffer
+		if err := rfs.tmpler.execute(&buf, tmpl, rfs.data(tmpl), execOptions{}); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+
+		info := renderedInfo{name: path.Base(name), size: int64(buf.Len())}
+		return &renderedFile{info, bytes.NewReader(buf.Bytes())}, nil
+	}
+
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+
+	children := make(map[string]bool) // name -> is directory
+	for p := range paths {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+
+		child, _, isDir := strings.Cut(strings.TrimPrefix(p, prefix), "/")
+		children[child] = children[child] || isDir
+	}
+
+	if len(children) == 0 && name != "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(children))
+	for child, isDir := range children {
+		entries = append(entries, renderedEntry{rfs, prefix + child, isDir})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	info := renderedInfo{name: path.Base(name), dir: true}
+	return &renderedDir{info, entries}, nil
+}
+
+// renderedEntry is a directory entry of a rendered file or directory. Files are
+// only rendered when their Info is needed.
+type renderedEntry struct {
+	rfs  renderedFS
+	path string
+	dir  bool
+}
+
+func (e renderedEntry) Name() string { return path.Base(e.path) }
+func (e renderedEntry) IsDir() bool  { return e.dir }
+
+func (e renderedEntry) Type() fs.FileMode {
+	if e.dir {
+		return fs.ModeDir
+	}
+	return 0
+}
+
+func (e renderedEntry) Info() (fs.FileInfo, error) {
+	if e.dir {
+		return renderedInfo{name: e.Name(), dir: true}, nil
+	}
+	return fs.Stat(e.rfs, e.path)
+}
+
+// renderedInfo is the fs.FileInfo of a rendered file or directory.
+type renderedInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (info renderedInfo) Name() string       { return info.name }
+func (info renderedInfo) Size() int64        { return info.size }
+func (info renderedInfo) ModTime() time.Time { return time.Time{} }
+func (info renderedInfo) IsDir() bool        { return info.dir }
+func (info renderedInfo) Sys() interface{}   { return nil }
+
+func (info renderedInfo) Mode() fs.FileMode {
+	if info.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+type renderedFile struct {
+	info renderedInfo
+	*bytes.Reader
+}
+
+func (f *renderedFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *renderedFile) Close() error               { return nil }
+
+type renderedDir struct {
+	info    renderedInfo
+	entries []fs.DirEntry
+}
+
+func (d *renderedDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *renderedDir) Close() error               { return nil }
+
+func (d *renderedDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *renderedDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
@@ -0,0 +1,146 @@
+package tmplutil
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestExport(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"index.html":      `<h1>{{ . }}</h1>`,
+		"blog/first.html": `<p>{{ . }}</p>`,
+	})
+	tmpler.RegisterString("../escaped", `escaped`)
+	tmpler.RegisterString("/rooted", `rooted`)
+
+	root := t.TempDir()
+	dir := filepath.Join(root, "out")
+
+	err := tmpler.Export(dir, func(name string) interface{} { return name })
+	if err == nil || !strings.Contains(err.Error(), "../escaped") || !strings.Contains(err.Error(), "/rooted") {
+		t.Errorf("expected the invalid names to be rejected, got %v", err)
+	}
+
+	files := map[string]string{
+		"index.html":      "<h1>index</h1>",
+		"blog/first.html": "<p>blog/first</p>",
+	}
+	for path, expected := range files {
+		b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+		if err != nil {
+			t.Errorf("failed to read %s: %v", path, err)
+			continue
+		}
+		if string(b) != expected {
+			t.Errorf("expected %s to be %q, got %q", path, expected, b)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "escaped.html")); err == nil {
+		t.Error("expected nothing to be written outside of the export directory")
+	}
+}
+
+func TestRenderedFS(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"about.html":     `<h1>{{ . }}</h1>`,
+		"blog/post.html": `<p>{{ . }}</p>`,
+	})
+	data := func(name string) interface{} { return "data for " + name }
+
+	rfs := tmpler.RenderedFS(data)
+
+	for _, name := range tmpler.RegisteredNames() {
+		path, _ := tmpler.Lookup(name)
+
+		b, err := fs.ReadFile(rfs, path)
+		if err != nil {
+			t.Errorf("failed to read %s: %v", path, err)
+			continue
+		}
+
+		if expect := mustRender(t, tmpler, name, data(name)); string(b) != expect {
+			t.Errorf("expected %s to be %q, got %q", path, expect, b)
+		}
+	}
+
+	if err := fstest.TestFS(rfs, "about.html", "blog/post.html"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRenderedFSIndex(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"index.html":     `<h1>home</h1>`,
+		"blog/index.md":  `blog index`,
+		"blog/post.html": `post`,
+	})
+	rfs := tmpler.RenderedFS(func(string) interface{} { return nil })
+
+	tests := map[string]string{
+		"":                "<h1>home</h1>",
+		"blog/":           "blog index",
+		"blog/index.html": "blog index",
+		"blog/index.md":   "blog index",
+	}
+	for name, expect := range tests {
+		f, err := rfs.Open(name)
+		if err != nil {
+			t.Errorf("failed to open %q: %v", name, err)
+			continue
+		}
+
+		b, err := io.ReadAll(f)
+		f.Close()
+		if err != nil || string(b) != expect {
+			t.Errorf("%q: expected %q, got %q, %v", name, expect, b, err)
+		}
+	}
+}
+
+func TestRenderedFSFileServer(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"about.html":   `<h1>about</h1>`,
+		"blog/post.md": `# {{ . }}`,
+	})
+	tmpler.PostProcessors = map[string]PostProcessor{".md": upperMarkdown}
+
+	server := http.FileServer(http.FS(tmpler.RenderedFS(func(name string) interface{} { return name })))
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{path: "/about.html", status: http.StatusOK, body: "<h1>about</h1>"},
+		{path: "/blog/post.html", status: http.StatusOK, body: "<h1>blog/post</h1>"},
+		{path: "/blog/post.md", status: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+
+		if rec.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.path, test.status, rec.Code)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		if typ := rec.Header().Get("Content-Type"); typ != "text/html; charset=utf-8" {
+			t.Errorf("%s: unexpected Content-Type %q", test.path, typ)
+		}
+		if body := rec.Body.String(); body != test.body {
+			t.Errorf("%s: expected %q, got %q", test.path, test.body, body)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package tmplutil
+
+import (
+	htmltemplate "html/template"
+	"io"
+	"sort"
+	texttemplate "text/template"
+	"text/template/parse"
+)
+
+// templateSet abstracts over html/template and text/template, which have the
+// same API but don't share any interface.
+type templateSet interface {
+	ExecuteTemplate(w io.Writer, name string, v interface{}) error
+	// parse parses src into a new template with the given name.
+	parse(name, src string) error
+	// clone returns a copy of the set.
+	clone() (templateSet, error)
+	// tree returns the parse tree of the given template or nil if there's no
+	// such template.
+	tree(name string) *parse.Tree
+	// names returns the sorted names of all templates in the set.
+	names() []string
+	// funcs adds or replaces the functions of the set.
+	funcs(fm htmltemplate.FuncMap)
+	// option sets options of the set, e.g. "missingkey=error".
+	option(opts ...string)
+}
+
+// rootName is the name of the empty template that all includes are associated
+// with. It's named so that errors about it, e.g. from cloning, don't refer to
+// an unnamed template, and it cannot be used as the name of an include, since
+// that would replace it.
+const rootName = "<root>"
+
+func (tmpler *Templater) newTemplateSet() templateSet {
+	funcs := tmpler.funcMap()
+
+	var set templateSet
+	if tmpler.TextMode {
+		t := texttemplate.New(rootName)
+		t = t.Delims(tmpler.delims[0], tmpler.delims[1])
+		t = t.Funcs(texttemplate.FuncMap(funcs))
+		set = textSet{t}
+	} else {
+		t := htmltemplate.New(rootName)
+		t = t.Delims(tmpler.delims[0], tmpler.delims[1])
+		t = t.Funcs(funcs)
+		set = htmlSet{t}
+	}
+
+	if tmpler.MissingKey != "" {
+		set.option("missingkey=" + tmpler.MissingKey)
+	}
+
+	return set
+}
+
+// funcMap returns the functions that templates are parsed with, which are the
+// Functions along with the built-in ones.
+func (tmpler *Templater) funcMap() htmltemplate.FuncMap {
+	funcs := copyMap(tmpler.Functions)
+	if funcs == nil {
+		funcs = htmltemplate.FuncMap{}
+	}
+	for name, bind := range tmpler.contextBinders() {
+		funcs[name] = bind(execOptions{})
+	}
+	if _, ok := funcs["asset"]; !ok && tmpler.Assets != nil {
+		funcs["asset"] = tmpler.asset
+	}
+	if _, ok := funcs["include"]; !ok {
+		funcs["include"] = tmpler.include
+	}
+
+	return funcs
+}
+
+type htmlSet struct{ *htmltemplate.Template }
+
+func (s htmlSet) parse(name, src string) error {
+	_, err := s.New(name).Parse(src)
+	return err
+}
+
+func (s htmlSet) clone() (templateSet, error) {
+	t, err := s.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return htmlSet{t}, nil
+}
+
+func (s htmlSet) tree(name string) *parse.Tree {
+	if t := s.Lookup(name); t != nil {
+		return t.Tree
+	}
+	return nil
+}
+
+func (s htmlSet) names() []string {
+	templates := s.Templates()
+
+	names := make([]string, 0, len(templates))
+	for _, t := range templates {
+		names = append(names, t.Name())
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (s htmlSet) funcs(fm htmltemplate.FuncMap) {
+	s.Funcs(fm)
+}
+
+func (s htmlSet) option(opts ...string) {
+	s.Option(opts...)
+}
+
+type textSet struct{ *texttemplate.Template }
+
+func (s textSet) parse(name, src string) error {
+	_, err := s.New(name).Parse(src)
+	return err
+}
+
+func (s textSet) clone() (templateSet, error) {
+	t, err := s.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return textSet{t}, nil
+}
+
+func (s textSet) tree(name string) *parse.Tree {
+	if t := s.Lookup(name); t != nil {
+		return t.Tree
+	}
+	return nil
+}
+
+func (s textSet) names() []string {
+	templates := s.Templates()
+
+	names := make([]string, 0, len(templates))
+	for _, t := range templates {
+		names = append(names, t.Name())
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (s textSet) funcs(fm htmltemplate.FuncMap) {
+	s.Funcs(texttemplate.FuncMap(fm))
+}
+
+func (s textSet) option(opts ...string) {
+	s.Option(opts...)
+}
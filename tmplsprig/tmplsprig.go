@@ -0,0 +1,25 @@
+// Package tmplsprig adds the sprig template functions to a Templater. It's
+// kept separate so that tmplutil itself doesn't depend on sprig.
+package tmplsprig
+
+import (
+	"html/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"libdb.so/tmplutil"
+)
+
+// Use merges the functions of sprig.HtmlFuncMap into the Functions of the
+// Templater, e.g. for {{ "hi" | upper }}. Functions that are already defined
+// are kept over sprig's. It should only be called before preloading.
+func Use(tmpler *tmplutil.Templater) {
+	if tmpler.Functions == nil {
+		tmpler.Functions = template.FuncMap{}
+	}
+
+	for name, fn := range sprig.HtmlFuncMap() {
+		if _, ok := tmpler.Functions[name]; !ok {
+			tmpler.Functions[name] = fn
+		}
+	}
+}
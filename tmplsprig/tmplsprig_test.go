@@ -0,0 +1,30 @@
+package tmplsprig
+
+import (
+	"html/template"
+	"testing"
+	"testing/fstest"
+
+	"libdb.so/tmplutil"
+)
+
+func TestUse(t *testing.T) {
+	tmpler := tmplutil.NewTemplater(fstest.MapFS{
+		"page.html": &fstest.MapFile{Data: []byte(`{{ "hi" | upper }} {{ "hi" | lower }}`)},
+	})
+	tmpler.Functions = template.FuncMap{
+		"lower": func(s string) string { return "custom " + s },
+	}
+	tmpler.Register("page", "page.html")
+
+	Use(tmpler)
+
+	out, err := tmpler.RenderString("page", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Existing functions are kept over sprig's.
+	if out != "HI custom hi" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
@@ -0,0 +1,35 @@
+// Package tmpltest provides helpers for testing templates.
+package tmpltest
+
+import (
+	"strings"
+	"testing"
+
+	"libdb.so/tmplutil"
+)
+
+// Render renders the subtemplate with the given data and returns the output.
+// The test fails immediately if the subtemplate fails to render.
+func Render(t testing.TB, sub *tmplutil.Subtemplate, v interface{}) string {
+	t.Helper()
+
+	out, err := sub.RenderString(v)
+	if err != nil {
+		t.Fatalf("failed to render %q: %v", sub.Name(), err)
+	}
+
+	return out
+}
+
+// RenderContains renders the subtemplate like Render and fails the test if the
+// output doesn't contain substr.
+func RenderContains(t testing.TB, sub *tmplutil.Subtemplate, v interface{}, substr string) string {
+	t.Helper()
+
+	out := Render(t, sub, v)
+	if !strings.Contains(out, substr) {
+		t.Errorf("rendered %q does not contain %q, got:\n%s", sub.Name(), substr, out)
+	}
+
+	return out
+}
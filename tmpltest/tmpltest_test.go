@@ -0,0 +1,21 @@
+package tmpltest
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"libdb.so/tmplutil"
+)
+
+func TestRender(t *testing.T) {
+	tmpler := tmplutil.NewTemplater(fstest.MapFS{
+		"greeting.html": &fstest.MapFile{Data: []byte(`<p>Hello, {{ . }}!</p>`)},
+	})
+	greeting := tmpler.Register("greeting", "greeting.html")
+
+	if out := Render(t, greeting, "alice"); out != "<p>Hello, alice!</p>" {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	RenderContains(t, greeting, "bob", "Hello, bob!")
+}
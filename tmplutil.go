@@ -1,57 +1,341 @@
 package tmplutil
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"io/fs"
 	"log"
+	"log/slog"
+	"mime"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	texttemplate "text/template"
+	"time"
 )
 
 // DebugMode, if true, will cause the following to happen:
 //
 //   - Errors and verbose template information will be logged.
-//   - The template will be reloaded on every request.
+//   - The template will be reloaded on every request, unless Watch is used.
 //
 // It will be toggled true if the environment variable "TMPL_DEBUG" is set to a
-// non-empty value (e.g. 1).
+// non-empty value (e.g. 1). Templater.Debug overrides it for a single
+// Templater.
 var DebugMode = os.Getenv("TMPL_DEBUG") != ""
 
+// debug returns whether the Templater is in debug mode, which is Debug if set
+// or DebugMode otherwise.
+func (tmpler *Templater) debug() bool {
+	if tmpler.Debug != nil {
+		return *tmpler.Debug
+	}
+	return DebugMode
+}
+
+// logf logs the formatted message to Logger at the given level, or to the
+// standard logger if Logger is nil.
+func (tmpler *Templater) logf(level slog.Level, format string, v ...interface{}) {
+	if tmpler.Logger != nil {
+		tmpler.Logger.Log(context.Background(), level, fmt.Sprintf(format, v...))
+		return
+	}
+
+	log.Printf("[tmplutil] "+format+"\n", v...)
+}
+
+// panicln logs the message to Logger like logf at the error level, then panics
+// with it.
+func (tmpler *Templater) panicln(v ...interface{}) {
+	msg := strings.TrimSuffix(fmt.Sprintln(v...), "\n")
+	tmpler.logf(slog.LevelError, "%s", msg)
+	panic(msg)
+}
+
 // Templater describes the template information to be constructed. Methods
 // called on Templater is NOT thread-safe, so it should only be called primarily
 // from the global scope or init.
 //
 // Templater must not be changed after it has been preloaded or executed. Doing
-// so after is undefined behavior and will trigger race conditions.
+// so after is undefined behavior and will trigger race conditions. The only
+// exception is RegisterSafe, which may be called at any time.
 type Templater struct {
 	// FileSystem is the filesystem to look up templates from. It must not be
-	// nil.
+	// nil, and it must be safe for concurrent use, since templates are read
+	// concurrently.
 	FileSystem fs.FS
 
-	Includes  map[string]string // name -> path
+	Includes map[string]string // name -> path
+
+	// Functions are the functions available to templates. Besides these, the
+	// following functions are always available unless overridden:
+	//
+	//   - "include" inlines a file from the FileSystem verbatim without
+	//     parsing it as a template, e.g. {{ include "icons/logo.svg" }}, so it
+	//     must only be used on trusted files.
+	//   - "render" executes another include with the given data through the
+	//     whole pipeline, including its PostProcessors, e.g. to embed a
+	//     markdown include using {{ render "intro" . }}. Calls may be nested
+	//     up to MaxRenderDepth times.
+	//   - "capture" executes any template, including {{define}} blocks, with
+	//     the given data and returns its output, e.g. to compute a title once
+	//     using {{ $title := capture "title" . }}. Like "render", calls may be
+	//     nested up to MaxRenderDepth times.
 	Functions template.FuncMap
 
+	// Extensions is the list of file extensions that files must have to be
+	// registered by Preregister, e.g. []string{".gohtml", ".tmpl"}. If nil,
+	// then HTMLExtensions is used, which is ".html" and ".htm".
+	Extensions []string
+
+	// Debug, if not nil, overrides DebugMode for this Templater only, e.g.
+	// to reload user templates on every request while internal ones are
+	// loaded once. Everything documented to happen in DebugMode follows it.
+	Debug *bool
+
+	// Logger is the logger that DebugMode messages and warnings are logged to.
+	// If nil, the standard logger is used.
+	Logger *slog.Logger
+
+	// DebugModTime, if true, will cause DebugMode to only reload the templates
+	// if the modification time of any of the included files has changed,
+	// instead of on every execution. Filesystems without modification times,
+	// such as embed.FS, are still reloaded on every execution.
+	DebugModTime bool
+
 	// OnRenderFail is called when the renderer fails. This function can be used
 	// to catch errors.
 	OnRenderFail RenderFailFunc
 
-	tmpl     *template.Template
-	tmplOnce sync.Once
+	// Errors, if not nil, receives every render failure that OnRenderFail is
+	// called for, e.g. to report them to an error tracker without knowing
+	// about it in OnRenderFail. Sending never blocks, so failures are dropped
+	// if the channel is full; it should be buffered. The channel must not be
+	// closed while templates may still be executed.
+	Errors chan<- RenderFailure
+
+	// BufferRenders, if true, will cause templates to be rendered into a
+	// buffer that is only written out once rendering succeeds. This way, when
+	// rendering fails, nothing has been written to the writer yet, so
+	// OnRenderFail can write a clean error page instead of appending to half a
+	// page. The cost is holding the whole output in memory and not streaming
+	// any of it until rendering is done, which also makes AlwaysFlush moot.
+	BufferRenders bool
+
+	// RenderTimeout, if not zero, is how long rendering may take before it's
+	// given up with ErrRenderTimeout, which is routed through OnRenderFail.
+	// Since templates cannot be interrupted, a template stuck in a function
+	// keeps running in its own goroutine until the function returns, but the
+	// caller is no longer blocked by it. Panics within that goroutine are
+	// always returned as errors like with RecoverPanics, since they would
+	// otherwise crash the program.
+	RenderTimeout time.Duration
+
+	// RecoverPanics, if true, will cause panics while rendering, e.g. from
+	// a PostProcessor or from templates failing to load, to be recovered and
+	// returned as errors, which are routed through OnRenderFail. The stack
+	// trace is logged in DebugMode. Panics within template functions are
+	// already returned as errors by the template package. Recovering may
+	// leave whatever the panicking code was changing in an inconsistent
+	// state.
+	RecoverPanics bool
+
+	// CacheSize is the maximum number of outputs that CachedExecute caches. If
+	// zero, then DefaultCacheSize is used.
+	CacheSize int
+
+	// OnRender, if not nil, is called after every execution with the name of
+	// the template, how long it took to render including post-processing, and
+	// the error if any. This function can be used to collect metrics.
+	OnRender func(name string, dur time.Duration, err error)
+
+	// OnParse, if not nil, is called with every include after it's parsed
+	// when the templates are loaded, so that it can be inspected or its parse
+	// tree rewritten, e.g. to add a hidden CSRF field to every form. If it
+	// returns an error, then loading fails with it. Trees are escaped by
+	// html/template only when first executed, so nodes added here are still
+	// escaped, but the tree must stay valid, and mutating it relies on the
+	// internals of text/template/parse, which may change. It is not called in
+	// TextMode.
+	//
+	// It's called while the Templater is locked for loading, so it must not
+	// call any of the Templater's methods, such as Has or Lookup, which would
+	// deadlock. Instead, t.Lookup and t.Templates find the other includes,
+	// which are all parsed by then, except for pages registered with a layout
+	// or their own functions, which are each parsed into their own copy.
+	OnParse func(name string, t *template.Template) error
+
+	// TextMode, if true, will cause templates to be parsed using text/template
+	// instead of html/template. This is useful for generating plain-text
+	// emails, JSON or configuration files.
+	//
+	// TextMode disables HTML escaping entirely, so it must never be used to
+	// render pages for the browser.
+	TextMode bool
+
+	// StrictNames, if true, will cause Preregister to return an error if
+	// multiple files have the same name.
+	StrictNames bool
+
+	// ValidateExecute, if true, will cause Validate to also execute every
+	// template with nil data.
+	ValidateExecute bool
+
+	// TrackUsage, if true, will cause every executed include to be recorded,
+	// so that UnusedNames can report the ones that were never executed. It is
+	// meant for finding dead templates while developing or profiling, not for
+	// production.
+	TrackUsage bool
+
+	// StrictReferences, if true, will cause parsing to fail if any template
+	// calls {{ template }} with the name of a template that doesn't exist, so
+	// that broken references are caught when preloading instead of when the
+	// call is executed. It should be left false if templates are added
+	// dynamically, e.g. using RegisterSafe.
+	StrictReferences bool
+
+	// MissingKey controls what happens when a template references a key that
+	// is missing from a map. It is one of "default", "zero", "error" or
+	// "invalid", which are the values of the "missingkey" option described in
+	// text/template. An empty string is the same as "default", which renders
+	// "<no value>" in TextMode and nothing otherwise. Parsing panics on any
+	// other value.
+	MissingKey string
+
+	// Assets is the filesystem to look up static assets from. If it's not nil,
+	// then the "asset" function is added, which appends a hash of the asset's
+	// content to its path for cache busting, e.g.
+	//
+	//	<link rel="stylesheet" href="{{ asset "/static/app.css" }}">
+	//
+	// renders "/static/app.css?v=0123456789ab", with the asset being read from
+	// "static/app.css" in Assets. Assets that cannot be read are left as-is.
+	// Hashes are cached until the templates are reloaded, except in DebugMode.
+	Assets fs.FS
+
+	// PostProcessors maps file extensions, e.g. ".css", to processors that the
+	// rendered output of includes with that extension is passed through before
+	// being written out.
+	PostProcessors map[string]PostProcessor
+
+	// PostProcessorFallback, if true, will cause the unprocessed output to be
+	// written HTML-escaped within a <pre> if a PostProcessor fails, e.g. if
+	// markdown fails to convert, so that the content is at least visible. The
+	// error is logged instead of being returned.
+	PostProcessorFallback bool
+
+	// Translations maps locales to the messages of each key, which the "t"
+	// function looks up in the locale set by WithLocale:
+	//
+	//	{{ t "greeting" .Name }}
+	//
+	// Messages are formatted with fmt.Sprintf if any arguments are given. If
+	// the key is missing, then the key itself is returned. Since the locale
+	// comes from the context, templates using "t" must be executed using
+	// ExecuteContext. Translations can be loaded from JSON files using
+	// LoadTranslations.
+	Translations map[string]map[string]string
+
+	// GlobalData, if not nil, returns data that every template may access
+	// under .Global, e.g. the current year or flash messages, without adding
+	// it to the data of every execution:
+	//
+	//	<footer>&copy; {{ .Global.Year }}</footer>
+	//
+	// It's called once per execution with the context given to
+	// ExecuteContext, or context.Background otherwise. Its result is merged
+	// into data that is nil, a map with string keys, which is copied into a
+	// map[string]interface{}, or a struct or a pointer to one that has a
+	// Global field of type map[string]interface{}, which is copied as well.
+	// The data given to Execute takes precedence, so an existing "Global" key
+	// or non-nil Global field is left alone, and data of any other type is
+	// left as-is. Data given to "render" and "capture" is not merged again.
+	GlobalData func(ctx context.Context) map[string]interface{}
+
+	// Minifier, if not nil, minifies the rendered output of every execution
+	// whose media type is one of MinifyTypes after the PostProcessors are
+	// applied, e.g. after markdown is converted to HTML. It is given the media
+	// type of the output without parameters, e.g. "text/html". It is not used
+	// in TextMode or DebugMode, so that the output stays readable while
+	// developing.
+	Minifier Minifier
+
+	// MinifyTypes is the list of media types, e.g. "text/css", of the outputs
+	// that the Minifier is used for, which are looked up like ContentType. If
+	// nil, then only "text/html" is minified.
+	MinifyTypes []string
+
+	// StripHTMLComments, if true, will cause HTML comments to be removed from
+	// the rendered HTML of every execution after the PostProcessors are
+	// applied, so that notes don't leak to clients. While html/template
+	// already removes comments written in templates, this also removes ones
+	// from template.HTML values, the "include" function and PostProcessors,
+	// e.g. comments within markdown. Conditional comments, e.g.
+	// "<!--[if IE]>", are kept. Comments are also matched within <script> and
+	// <style>, so those must not contain "<!--". Only outputs that are
+	// "text/html" according to ContentType are stripped, and not in TextMode
+	// or DebugMode.
+	StripHTMLComments bool
+
+	// OutputFilters are applied in order to the rendered output of every
+	// execution after the PostProcessors and the Minifier, e.g. to inject an
+	// analytics snippet. Each filter is given the output of the previous one.
+	// If any filter fails, then nothing is written and the error is returned.
+	// The input is reused once the execution returns, so filters must not
+	// retain it.
+	OutputFilters []func(in []byte) ([]byte, error)
+
+	// SkipOutputFiltersInDebug, if true, will cause OutputFilters to not be
+	// applied in DebugMode.
+	SkipOutputFiltersInDebug bool
+
+	delims  [2]string
+	layouts map[string]string           // name -> layout name
+	pageFns map[string]template.FuncMap // name -> functions given to RegisterWithFuncs
+	sandbox map[string][]string         // name -> functions allowed by RegisterSandboxed
+	sources map[string]string           // name -> source read by the last parse
+	strSrcs map[string]string           // name -> source given to RegisterString
+
+	modTimes map[string]time.Time // path -> modification time at the last parse
+
+	contextFuncs map[string]ContextFunc
+	cache        pageCache
+	used         sync.Map     // name -> struct{}
+	tmpl         atomic.Value // *templates
+	tmplMu       sync.Mutex
+	watching     int32
 }
 
 // HTMLExtensions is the list of HTML file extensions that files must have to be
-// considered a template.
+// considered a template if the Templater has no Extensions.
+//
+// Deprecated: Use Templater.Extensions instead.
 var HTMLExtensions = []string{".html", ".htm"}
 
-func isHTML(path string) bool {
+// isTemplate returns true if the file has one of the Extensions.
+func (tmpler *Templater) isTemplate(path string) bool {
+	exts := tmpler.Extensions
+	if exts == nil {
+		exts = HTMLExtensions
+	}
+
 	pathExt := filepath.Ext(path)
 
-	for _, ext := range HTMLExtensions {
+	for _, ext := range exts {
 		if ext == pathExt {
 			return true
 		}
@@ -60,40 +344,104 @@ func isHTML(path string) bool {
 	return false
 }
 
-// Preregister registers all templates with the filetype ".html" and ".htm" from
-// the given FileSystem. The basename without the file extension will be used,
-// and duplicated names will be ignored. If no paths are given, then the current
-// directory is used.
+// Preregister registers all templates with one of the Extensions, which are
+// ".html" and ".htm" by default, from the given FileSystem. The basename
+// without the file extension will be used, and duplicated names will be
+// ignored. If no paths are given, then the current directory is used. Anything
+// that isn't a regular file, such as a symlink, is skipped.
+//
+// Since only the basename is used, files in different directories may collide,
+// e.g. "blog/index.html" and "docs/index.html". The first file found wins, and
+// the others are silently dropped unless StrictNames is true, in which case an
+// error listing all collisions is returned.
 //
 // Use the Subtemplate method to get the subtemplate, or call Register with an
 // empty path.
 //
 // The list of valid filetypes to be considered templates can be changed in
-// tmplutil.HTMLExtensions.
+// Extensions.
 func (tmpler *Templater) Preregister(paths ...string) error {
+	return tmpler.PreregisterFunc(func(path string) (string, bool) {
+		if !tmpler.isTemplate(path) {
+			return "", false
+		}
+		name := filepath.Base(path)
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+		return name, true
+	}, paths...)
+}
+
+// PreregisterFunc is like Preregister, except nameFn decides the name of each
+// file found, e.g. to prefix names with their directory. Files that nameFn
+// returns false for are skipped, regardless of Extensions.
+func (tmpler *Templater) PreregisterFunc(nameFn func(path string) (name string, include bool), paths ...string) error {
+	return tmpler.preregister(paths, nameFn)
+}
+
+// PreregisterPaths is like Preregister, except the full path of each file
+// without the file extension is used as its name, e.g. "users/list" for
+// "users/list.html", so files with the same basename don't collide.
+func (tmpler *Templater) PreregisterPaths(paths ...string) error {
+	return tmpler.preregister(paths, func(path string) (string, bool) {
+		if !tmpler.isTemplate(path) {
+			return "", false
+		}
+		return strings.TrimSuffix(path, filepath.Ext(path)), true
+	})
+}
+
+// preregister walks the given paths and registers every file that nameFn
+// returns a name for.
+func (tmpler *Templater) preregister(paths []string, nameFn func(path string) (name string, ok bool)) error {
 	if len(paths) == 0 {
 		paths = []string{"."}
 	}
 
+	if tmpler.Includes == nil {
+		tmpler.Includes = map[string]string{}
+	}
+
+	collisions := make(map[string][]string)
+
 	walkFn := func(fullPath string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
-		name := d.Name()
-		if !isHTML(name) {
+		if d.IsDir() {
 			return nil
 		}
 
-		name = filepath.Base(name)
-		name = strings.TrimSuffix(name, filepath.Ext(name))
+		// Skip symlinks, named pipes and such, since reading them may block or
+		// fail in confusing ways.
+		if !d.Type().IsRegular() {
+			if tmpler.debug() {
+				tmpler.logf(slog.LevelDebug, "skipping %s since it's not a regular file", fullPath)
+			}
+			return nil
+		}
 
-		if _, ok := tmpler.Includes[name]; ok {
+		name, ok := nameFn(fullPath)
+		if !ok {
+			return nil
+		}
+
+		if name == rootName {
+			return fmt.Errorf("%s cannot be named %q", fullPath, rootName)
+		}
+
+		if path, ok := tmpler.Includes[name]; ok {
+			if path != fullPath {
+				if tmpler.debug() {
+					tmpler.logf(slog.LevelDebug, "ignoring %s since %s is already at %s", fullPath, name, path)
+				}
+				collisions[name] = append(collisions[name], fullPath)
+			}
 			return nil
 		}
 
-		if DebugMode {
-			log.Println("Pre-registering", name, "at", fullPath)
+		if tmpler.debug() {
+			tmpler.logf(slog.LevelDebug, "pre-registering %s at %s", name, fullPath)
 		}
 
 		tmpler.Includes[name] = fullPath
@@ -101,61 +449,307 @@ func (tmpler *Templater) Preregister(paths ...string) error {
 	}
 
 	for _, path := range paths {
+		path, err := cleanPath(path)
+		if err != nil {
+			return err
+		}
+
 		if err := fs.WalkDir(tmpler.FileSystem, path, walkFn); err != nil {
 			return fmt.Errorf("failed to walk directory %q: %w", path, err)
 		}
 	}
 
+	if tmpler.StrictNames && len(collisions) > 0 {
+		names := make([]string, 0, len(collisions))
+		for name := range collisions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for i, name := range names {
+			paths := append([]string{tmpler.Includes[name]}, collisions[name]...)
+			names[i] = fmt.Sprintf("%q (%s)", name, strings.Join(paths, ", "))
+		}
+
+		return fmt.Errorf("colliding template names: %s", strings.Join(names, "; "))
+	}
+
 	return nil
 }
 
+// NewTemplater creates a new Templater with the given filesystem and empty
+// Includes and Functions.
+func NewTemplater(fsys fs.FS) *Templater {
+	return &Templater{
+		FileSystem: fsys,
+		Includes:   map[string]string{},
+		Functions:  template.FuncMap{},
+	}
+}
+
+// FromEmbed creates a new Templater with all templates in the given directory
+// of the embedded filesystem preregistered. The returned Templater is ready to
+// be preloaded. It panics on errors.
+//
+//	//go:embed templates
+//	var templatesFS embed.FS
+//
+//	var Templater = tmplutil.FromEmbed(templatesFS, "templates")
+func FromEmbed(efs embed.FS, dir string) *Templater {
+	tmpler := NewTemplater(MustSub(efs, dir))
+
+	if err := tmpler.Preregister(); err != nil {
+		tmpler.panicln(err)
+	}
+
+	return tmpler
+}
+
 // Preregister calls [tmpler.Preregister]. It panics on errors.
 //
-// Deprecated: Use [tmpler.Preregister] instead.
+// Deprecated: Use [tmpler.Preregister] or PreregisterErr instead.
 func Preregister(tmpler *Templater) *Templater {
-	if err := tmpler.Preregister(); err != nil {
-		log.Panicln(err)
+	tmpler, err := PreregisterErr(tmpler)
+	if err != nil {
+		tmpler.panicln(err)
 	}
 	return tmpler
 }
 
+// PreregisterErr is like Preregister, except it returns the error instead of
+// panicking, e.g. if the FileSystem cannot be walked.
+func PreregisterErr(tmpler *Templater) (*Templater, error) {
+	if err := tmpler.Preregister(); err != nil {
+		return tmpler, err
+	}
+	return tmpler, nil
+}
+
+// ErrRenderTimeout is returned if rendering takes longer than RenderTimeout.
+var ErrRenderTimeout = errors.New("render timed out")
+
+// ErrTemplateNotRegistered is returned when executing a template that was never
+// registered.
+type ErrTemplateNotRegistered struct {
+	Name string
+}
+
+// Error implements error.
+func (err ErrTemplateNotRegistered) Error() string {
+	return fmt.Sprintf("template %q is not registered", err.Name)
+}
+
+// PostProcessor is a function that processes the rendered output of a template
+// and writes the result to w. Its output is buffered, so nothing is written if
+// it fails. The input is reused once it returns, so it must not be retained.
+// Refer to PostProcessors.
+type PostProcessor func(in []byte, w io.Writer) error
+
+// RenderMarkdown converts the given markdown using the PostProcessor for ".md"
+// files without executing it as a template, e.g. to render markdown stored in a
+// database the same way as markdown includes. It returns an error if there's
+// no such PostProcessor.
+func (tmpler *Templater) RenderMarkdown(w io.Writer, src []byte) error {
+	process, ok := tmpler.PostProcessors[".md"]
+	if !ok {
+		return errors.New("no PostProcessor for .md")
+	}
+	return postProcess(process, src, w)
+}
+
+// WarmMarkdown converts a small markdown document using the PostProcessor for
+// ".md" files and discards the result, so that any lazy setup of the converter,
+// e.g. loading syntax highlighting lexers, doesn't slow down the first request.
+// It's meant to be called along with Preload. It does nothing if there's no
+// such PostProcessor.
+func (tmpler *Templater) WarmMarkdown() error {
+	if _, ok := tmpler.PostProcessors[".md"]; !ok {
+		return nil
+	}
+	return tmpler.RenderMarkdown(io.Discard, []byte(warmMarkdown))
+}
+
+const warmMarkdown = "# Warm\n\n*Warming* the [converter](/).\n\n```go\npackage main\n```\n"
+
+// postProcess passes the input through the processor into a buffer and only
+// writes it to w once the processor succeeds, so that a processor failing
+// halfway doesn't leave w with partial output.
+func postProcess(process PostProcessor, in []byte, w io.Writer) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	if err := process(in, buf); err != nil {
+		return err
+	}
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// rawFallback wraps the processor to write its input escaped within a <pre>
+// instead if it fails. Refer to PostProcessorFallback.
+func (tmpler *Templater) rawFallback(name string, process PostProcessor) PostProcessor {
+	return func(in []byte, w io.Writer) error {
+		err := postProcess(process, in, w)
+		if err == nil {
+			return nil
+		}
+
+		tmpler.logf(slog.LevelError, "failed to post-process %q, writing it raw: %v", name, err)
+
+		if _, err := io.WriteString(w, "<pre>"); err != nil {
+			return err
+		}
+		template.HTMLEscape(w, in)
+		_, err = io.WriteString(w, "</pre>")
+		return err
+	}
+}
+
+// Minifier minifies the content of the given media type read from r and writes
+// the result to w. Its method matches the one of github.com/tdewolff/minify's
+// M, so a *minify.M can be used as-is. Refer to Templater.Minifier.
+type Minifier interface {
+	Minify(mediatype string, w io.Writer, r io.Reader) error
+}
+
+// MinifierFunc is a function that implements Minifier.
+type MinifierFunc func(mediatype string, w io.Writer, r io.Reader) error
+
+// Minify implements Minifier.
+func (f MinifierFunc) Minify(mediatype string, w io.Writer, r io.Reader) error {
+	return f(mediatype, w, r)
+}
+
 // RenderFailFunc is the function that's called when a template render fails.
 // Refer to OnRenderFail.
 type RenderFailFunc func(sub *Subtemplate, w io.Writer, err error)
 
-// failWriter wraps around the writer to be used within onRenderFail to break
-// the recursion chain.
-type failWriter struct{ io.Writer }
+// RenderFailure describes a failed render. Refer to Templater.Errors.
+type RenderFailure struct {
+	// Name is the name of the executed include.
+	Name string
+	// Path is the path of the executed include.
+	Path string
+	// Err is the error that rendering failed with.
+	Err error
+	// Time is when rendering failed.
+	Time time.Time
+}
+
+// MaxRenderFailDepth is the maximum number of nested calls to OnRenderFail,
+// e.g. if the error template rendered by OnRenderFail fails as well. With the
+// default of 1, failures within OnRenderFail are not passed to it again.
+// Raising it allows OnRenderFail to fall back to a simpler error template.
+var MaxRenderFailDepth = 1
+
+// failWriter wraps around the writer to be used within onRenderFail, keeping
+// track of how many calls to OnRenderFail it's nested in to break the
+// recursion chain.
+type failWriter struct {
+	io.Writer
+	depth int
+}
+
+// failDepth returns the number of OnRenderFail calls that the writer is nested
+// in.
+func failDepth(w io.Writer) int {
+	if fw, ok := w.(failWriter); ok {
+		return fw.depth
+	}
+	return 0
+}
 
 func (tmpler *Templater) onRenderFail(w io.Writer, tmpl string, err error) {
 	if err == nil {
 		return
 	}
 
-	if DebugMode {
-		log.Printf("[tmplutil] failed to render %q: %v\n", tmpl, err)
+	if tmpler.debug() {
+		tmpler.logf(slog.LevelError, "failed to render %q: %v", tmpl, err)
+	}
+
+	if tmpler.Errors != nil {
+		path, _ := tmpler.Lookup(tmpl)
+		select {
+		case tmpler.Errors <- RenderFailure{tmpl, path, err, time.Now()}:
+		default:
+		}
 	}
 
 	if tmpler.OnRenderFail != nil {
-		// Check if we're already in an onRenderFail callchain by checking if the
-		// writer is wrapped.
-		if _, ok := w.(failWriter); ok {
-			// Break the callchain if yes to avoid recursion loops.
+		// Break the callchain if we're already nested too deep within
+		// OnRenderFail to avoid recursion loops.
+		depth := failDepth(w)
+		if depth >= MaxRenderFailDepth {
+			if tmpler.debug() && depth > 0 {
+				tmpler.logf(slog.LevelWarn, "not calling OnRenderFail for %q: exceeded maximum depth %d", tmpl, MaxRenderFailDepth)
+			}
 			return
 		}
 
+		if fw, ok := w.(failWriter); ok {
+			w = fw.Writer
+		}
+
 		sub := &Subtemplate{tmpler, tmpl}
-		tmpler.OnRenderFail(sub, failWriter{w}, err)
+		tmpler.OnRenderFail(sub, failWriter{w, depth + 1}, err)
+	}
+}
+
+// RenderError renders the given error template into w. It is meant to be
+// called from within OnRenderFail to render an error page, e.g.
+//
+//	tmpler.OnRenderFail = func(sub *tmplutil.Subtemplate, w io.Writer, err error) {
+//		sub.Templater().RenderError(w, "error", err.Error())
+//	}
+//
+// If the error template itself fails to render, then OnRenderFail is called
+// again as long as MaxRenderFailDepth allows it, so that it may fall back to
+// another template. Otherwise, a plain-text message is written instead.
+func (tmpler *Templater) RenderError(w io.Writer, errorTmpl string, v interface{}) {
+	// Guard the writer so that a failing error template doesn't loop back into
+	// OnRenderFail if we're not already in the callchain.
+	if _, ok := w.(failWriter); !ok {
+		w = failWriter{w, MaxRenderFailDepth}
+	}
+
+	retries := failDepth(w) < MaxRenderFailDepth
+
+	if err := tmpler.Execute(w, errorTmpl, v); err != nil && !retries {
+		io.WriteString(w, errorFallback)
 	}
 }
 
+const errorFallback = "An error occurred while rendering this page.\n"
+
 // Register registers a subtemplate. If a template is already not
 // pre-registered, then it is registered. Otherwise, the pre-registered template
 // is used.
+//
+// Backslashes in the path are treated as forward slashes. Paths that are not
+// valid according to fs.ValidPath, e.g. ones containing "..", are logged and
+// fail to load. The name "<root>" is reserved and is logged and ignored.
 func (tmpler *Templater) Register(name, path string) *Subtemplate {
+	if tmpler.Includes == nil {
+		tmpler.Includes = map[string]string{}
+	}
+
+	if name == rootName {
+		tmpler.logf(slog.LevelError, "failed to register %q: name is reserved", name)
+		return &Subtemplate{tmpler, name}
+	}
+
 	if _, ok := tmpler.Includes[name]; !ok {
-		if DebugMode {
-			log.Println("Registering", path)
+		if path != "" {
+			var err error
+			if path, err = cleanPath(path); err != nil {
+				tmpler.logf(slog.LevelError, "failed to register %q: %v", name, err)
+			}
+		}
+
+		if tmpler.debug() {
+			tmpler.logf(slog.LevelDebug, "registering %s", path)
 		}
 
 		tmpler.Includes[name] = path
@@ -164,107 +758,1318 @@ func (tmpler *Templater) Register(name, path string) *Subtemplate {
 	return &Subtemplate{tmpler, name}
 }
 
-// Override overrides the template source files. It does not re-render
-// templates.
-func (tmpler *Templater) Override(overrideFS fs.FS) {
-	tmpler.FileSystem = OverrideFS(tmpler.FileSystem, overrideFS)
-}
+// RegisterString registers a subtemplate with the given source instead of a
+// file, e.g. for tests or templates generated by plugins. Unlike Register, it
+// replaces any include with the same name. The include has no path, so its
+// source never changes, even when files are reread in DebugMode. It should
+// only be called before preloading.
+func (tmpler *Templater) RegisterString(name, src string) *Subtemplate {
+	if name == rootName {
+		tmpler.logf(slog.LevelError, "failed to register %q: name is reserved", name)
+		return &Subtemplate{tmpler, name}
+	}
+
+	if tmpler.Includes == nil {
+		tmpler.Includes = map[string]string{}
+	}
+	if tmpler.strSrcs == nil {
+		tmpler.strSrcs = make(map[string]string)
+	}
+
+	tmpler.Includes[name] = ""
+	tmpler.strSrcs[name] = src
 
-// Subtemplate returns a registered subtemplate. If the template isn't yet
-// registered, a subtemplate instance will still be returned, but executing it
-// will return an error.
-func (tmpler *Templater) Subtemplate(name string) *Subtemplate {
 	return &Subtemplate{tmpler, name}
 }
 
-// Execute executes any subtemplate.
-func (tmpler *Templater) Execute(w io.Writer, tmpl string, v interface{}) error {
-	if err := tmpler.Load().ExecuteTemplate(w, tmpl, v); err != nil {
-		tmpler.onRenderFail(w, tmpl, err)
-		return err
+// readSource reads the source of the include with the given name.
+func (tmpler *Templater) readSource(name string) (string, error) {
+	if src, ok := tmpler.strSrcs[name]; ok {
+		return src, nil
 	}
-	return nil
+	b, err := fs.ReadFile(tmpler.FileSystem, tmpler.Includes[name])
+	return string(b), err
 }
 
-// Func registers a function; it should only be called before preloading. The
-// function will panic if there's a duplicate function.
-func (tmpler *Templater) Func(name string, fn interface{}) {
-	if _, ok := tmpler.Functions[name]; ok {
-		log.Panicln("error: duplicate function with name", name)
+// RegisterSafe registers a subtemplate like Register, except it is safe to call
+// concurrently with Execute, even after the templates have been loaded. If the
+// templates are already loaded, then they're reparsed with the new subtemplate
+// and swapped in atomically, so executions that are already running will keep
+// using the old templates.
+func (tmpler *Templater) RegisterSafe(name, path string) *Subtemplate {
+	tmpler.tmplMu.Lock()
+	defer tmpler.tmplMu.Unlock()
+
+	sub := tmpler.Register(name, path)
+
+	if tmpler.loaded() != nil {
+		tmpler.tmpl.Store(tmpler.parse())
 	}
-	tmpler.Functions[name] = fn
+
+	return sub
 }
 
-// Preload preloads the templates once. If the templates are already
-// preloaded, then it does nothing.
-func (tmpler *Templater) Preload() {
-	tmpler.Load()
+// RegisterWithLayout registers a subtemplate like Register, except executing it
+// will execute the given layout instead, with the blocks defined in the page
+// taking precedence over the layout's.
+//
+// For example, given a layout that has the following:
+//
+//	<main>{{ block "content" . }}No content.{{ end }}</main>
+//
+// A page may override the "content" block like so:
+//
+//	{{ define "content" }}<p>Hello, world!</p>{{ end }}
+//
+// Each page is parsed into its own copy of the shared templates, so pages using
+// the same layout may all define their own "content" block without clashing.
+// The layout must be a registered template.
+func (tmpler *Templater) RegisterWithLayout(name, path, layout string) *Subtemplate {
+	sub := tmpler.Register(name, path)
+
+	if tmpler.layouts == nil {
+		tmpler.layouts = make(map[string]string)
+	}
+	tmpler.layouts[name] = layout
+
+	return sub
 }
 
-// Load loads the templates. If the templates are already loaded, then it does
-// nothing.
-func (tmpler *Templater) Load() *template.Template {
-	parse := func() *template.Template {
-		tmpl := template.New("")
-		tmpl = tmpl.Funcs(tmpler.Functions)
-		for name, incl := range tmpler.Includes {
-			tmpl = template.Must(tmpl.New(name).Parse(readFile(tmpler.FileSystem, incl)))
+// RegisterWithFuncs registers a subtemplate like Register, except the given
+// functions replace or add to the Functions for it alone, e.g. for a "url"
+// helper that differs for a single page. Since functions cannot be changed
+// per template once parsed, the subtemplate is parsed into its own copy of the
+// shared templates like pages registered with a layout, which costs a clone of
+// every template on each load. Other templates cannot reference it with
+// {{template}}.
+func (tmpler *Templater) RegisterWithFuncs(name, path string, fm template.FuncMap) *Subtemplate {
+	sub := tmpler.Register(name, path)
+
+	if tmpler.pageFns == nil {
+		tmpler.pageFns = make(map[string]template.FuncMap)
+	}
+	tmpler.pageFns[name] = fm
+
+	return sub
+}
+
+// RegisterSandboxed registers a subtemplate like RegisterWithFuncs, except
+// instead of adding functions, only the functions with the given names may be
+// called, e.g. for templates supplied by users. This covers both Functions and
+// built-in functions such as "include", but not the functions predefined by
+// text/template, such as "len" and "printf". Calling any other function fails
+// the execution, including from the templates that it executes using
+// {{template}}.
+func (tmpler *Templater) RegisterSandboxed(name, path string, allowed []string) *Subtemplate {
+	sub := tmpler.Register(name, path)
+
+	if tmpler.sandbox == nil {
+		tmpler.sandbox = make(map[string][]string)
+	}
+	tmpler.sandbox[name] = allowed
+
+	return sub
+}
+
+// pageFuncMaps returns the functions of every page that has its own, which are
+// the disallowed functions of sandboxed pages replaced by ones that fail, along
+// with the functions given to RegisterWithFuncs.
+func (tmpler *Templater) pageFuncMaps() map[string]template.FuncMap {
+	pageFns := make(map[string]template.FuncMap, len(tmpler.pageFns)+len(tmpler.sandbox))
+
+	if len(tmpler.sandbox) > 0 {
+		funcs := tmpler.funcMap()
+
+		for name, allowed := range tmpler.sandbox {
+			isAllowed := make(map[string]bool, len(allowed))
+			for _, fn := range allowed {
+				isAllowed[fn] = true
+			}
+
+			fm := make(template.FuncMap)
+			for fn := range funcs {
+				if !isAllowed[fn] {
+					fm[fn] = disallowedFunc(fn)
+				}
+			}
+			pageFns[name] = fm
 		}
-		return tmpl
 	}
 
-	if DebugMode {
-		return parse()
+	for name, fm := range tmpler.pageFns {
+		if pageFns[name] == nil {
+			pageFns[name] = make(template.FuncMap, len(fm))
+		}
+		for fn, v := range fm {
+			pageFns[name][fn] = v
+		}
 	}
 
-	tmpler.tmplOnce.Do(func() { tmpler.tmpl = parse() })
-	return tmpler.tmpl
+	return pageFns
 }
 
-// Reset resets the template to its initial state.
-func (tmpler *Templater) Reset() {
-	tmpler.tmpl = nil
-	tmpler.tmplOnce = sync.Once{}
+// disallowedFunc returns a function that always fails, which replaces the
+// functions that sandboxed templates may not call.
+func disallowedFunc(name string) func(...interface{}) (interface{}, error) {
+	return func(...interface{}) (interface{}, error) {
+		return nil, fmt.Errorf("function %q is not allowed", name)
+	}
 }
 
-// Subtemplate describes a subtemplate that belongs to some parent template.
-type Subtemplate struct {
-	tmpl *Templater
-	name string
+// UnusedNames returns the sorted names of all registered includes that have not
+// been executed since TrackUsage was enabled. Includes that are only used from
+// within other templates, e.g. by {{ template "header" }}, are never executed
+// themselves and are therefore also reported.
+func (tmpler *Templater) UnusedNames() []string {
+	var unused []string
+	for _, name := range tmpler.RegisteredNames() {
+		if _, ok := tmpler.used.Load(name); !ok {
+			unused = append(unused, name)
+		}
+	}
+	return unused
 }
 
-// Templater gets the parent templater of the subtemplate.
-func (sub *Subtemplate) Templater() *Templater {
-	return sub.tmpl
+// RegisteredNames returns the sorted names of all registered includes.
+func (tmpler *Templater) RegisteredNames() []string {
+	tmpler.tmplMu.Lock()
+	defer tmpler.tmplMu.Unlock()
+
+	names := make([]string, 0, len(tmpler.Includes))
+	for name := range tmpler.Includes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
 }
 
-// Name gets the subtemplate's name.
-func (sub *Subtemplate) Name() string {
-	return sub.name
+// Lookup returns the path of the registered include with the given name. The
+// path is looked up in the FileSystem, including any overrides applied using
+// Override.
+func (tmpler *Templater) Lookup(name string) (path string, ok bool) {
+	tmpler.tmplMu.Lock()
+	defer tmpler.tmplMu.Unlock()
+
+	path, ok = tmpler.Includes[name]
+	return
 }
 
-// Execute executes the subtemplate.
-func (sub *Subtemplate) Execute(w io.Writer, v interface{}) error {
-	return sub.tmpl.Execute(w, sub.name, v)
+// Has returns true if an include with the given name is registered, e.g. to
+// only execute optional partials of a theme if they exist.
+func (tmpler *Templater) Has(name string) bool {
+	_, ok := tmpler.Lookup(name)
+	return ok
 }
 
-// MustSubFS forces creation of a sub-filesystem using fs.Sub. It panics on
-// errors.
-func MustSub(fsys fs.FS, dir string) fs.FS {
-	sub, err := fs.Sub(fsys, dir)
-	if err != nil {
-		log.Panicln(err)
-	}
-	return sub
+// Override overrides the template source files. It does not re-render
+// templates.
+func (tmpler *Templater) Override(overrideFS fs.FS) {
+	tmpler.FileSystem = OverrideFS(tmpler.FileSystem, overrideFS)
 }
 
-func readFile(fsys fs.FS, filePath string) string {
-	b, err := fs.ReadFile(fsys, filePath)
-	if err != nil {
-		log.Fatalln("failed to read file:", err)
+// OverrideAndReload overrides the template source files like Override, except
+// the templates are parsed again right away and swapped in atomically like
+// Reload, e.g. to switch themes at runtime. If the templates fail to parse
+// with the new files, then the override is undone, the current templates keep
+// being used, and the error is returned.
+func (tmpler *Templater) OverrideAndReload(overrideFS fs.FS) error {
+	tmpler.tmplMu.Lock()
+	defer tmpler.tmplMu.Unlock()
+
+	oldFS := tmpler.FileSystem
+	tmpler.FileSystem = OverrideFS(oldFS, overrideFS)
+
+	if err := tmpler.reload(); err != nil {
+		tmpler.FileSystem = oldFS
+		tmpler.sources = nil
+		return err
 	}
 
-	return string(b)
+	return nil
+}
+
+// Version returns a hash of the names, paths and sources of all includes, which
+// changes whenever the templates are reloaded with different sources. This
+// helps correlating rendered pages with the templates that rendered them.
+// Refer to VersionMiddleware.
+func (tmpler *Templater) Version() string {
+	return tmpler.load().version
+}
+
+// Subtemplate returns a registered subtemplate. If the template isn't yet
+// registered, a subtemplate instance will still be returned, but executing it
+// will return an error.
+func (tmpler *Templater) Subtemplate(name string) *Subtemplate {
+	return &Subtemplate{tmpler, name}
+}
+
+// Execute executes any subtemplate.
+func (tmpler *Templater) Execute(w io.Writer, tmpl string, v interface{}) error {
+	if err := tmpler.execute(w, tmpl, v, execOptions{}); err != nil {
+		tmpler.onRenderFail(w, tmpl, err)
+		return err
+	}
+	return nil
+}
+
+// ExecuteContext executes any subtemplate with the given context. If the
+// context is already cancelled, then ctx.Err() is returned and w is never
+// written to. If the context is cancelled while rendering, then the render is
+// aborted on the next write, and OnRenderFail is not called, since there is
+// likely no one left to receive the error.
+func (tmpler *Templater) ExecuteContext(ctx context.Context, w io.Writer, tmpl string, v interface{}) error {
+	return tmpler.executeContext(w, tmpl, v, execOptions{ctx: ctx})
+}
+
+func (tmpler *Templater) executeContext(w io.Writer, tmpl string, v interface{}, opts execOptions) error {
+	if err := opts.ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := tmpler.execute(contextWriter{w, opts.ctx}, tmpl, v, opts); err != nil {
+		if opts.ctx.Err() == nil {
+			tmpler.onRenderFail(w, tmpl, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// Stream executes the header subtemplate, then the row subtemplate for every
+// item received from the channel until it's closed, then the footer
+// subtemplate, flushing the writer after each if it's an http.Flusher. This
+// allows rendering a long list without holding all of it in memory. The header
+// and footer are executed with nil data and are skipped if their names are
+// empty.
+//
+// If any subtemplate fails, then Stream returns its error right away without
+// receiving from the channel anymore, so the sender must not block forever,
+// e.g. by also selecting on the request's context.
+func (tmpler *Templater) Stream(w io.Writer, header, row, footer string, items <-chan interface{}) error {
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	if header != "" {
+		if err := tmpler.Execute(w, header, nil); err != nil {
+			return err
+		}
+		flush()
+	}
+
+	for item := range items {
+		if err := tmpler.Execute(w, row, item); err != nil {
+			return err
+		}
+		flush()
+	}
+
+	if footer != "" {
+		if err := tmpler.Execute(w, footer, nil); err != nil {
+			return err
+		}
+		flush()
+	}
+
+	return nil
+}
+
+// ExecuteRaw executes any subtemplate like Execute, except the output is
+// written as-is without going through PostProcessors or the Minifier, e.g. to
+// get the templated markdown of a ".md" include instead of its HTML.
+func (tmpler *Templater) ExecuteRaw(w io.Writer, tmpl string, v interface{}) error {
+	if err := tmpler.execute(w, tmpl, v, execOptions{raw: true}); err != nil {
+		tmpler.onRenderFail(w, tmpl, err)
+		return err
+	}
+	return nil
+}
+
+// ExecuteLocalized executes the localized variant of the subtemplate for the
+// given locale, falling back to the subtemplate itself. A variant is an include
+// named "<tmpl>.<locale>", which Preregister creates from files like
+// "greeting.fr.html". Locales with a region, e.g. "fr-CA", fall back to the
+// language, e.g. "fr", before falling back to the subtemplate.
+func (tmpler *Templater) ExecuteLocalized(w io.Writer, tmpl, locale string, v interface{}) error {
+	return tmpler.Execute(w, tmpler.localized(tmpl, locale), v)
+}
+
+// localized returns the name of the localized variant of the template.
+func (tmpler *Templater) localized(tmpl, locale string) string {
+	for locale != "" {
+		name := tmpl + "." + locale
+		if _, ok := tmpler.Lookup(name); ok {
+			return name
+		}
+
+		i := strings.LastIndexAny(locale, "-_")
+		if i == -1 {
+			break
+		}
+		locale = locale[:i]
+	}
+
+	return tmpl
+}
+
+// ExecuteBlock executes only the block with the given name that is defined by
+// {{define}} or {{block}} within any subtemplate. This is useful for rendering
+// a fragment of a page, e.g. for HTMX partial swaps. Block names must be unique
+// across all templates, except for blocks defined by pages registered with a
+// layout, which are looked up in the page's own copy of the templates.
+//
+// Unlike Execute, the output is not passed through PostProcessors or the
+// Minifier.
+func (tmpler *Templater) ExecuteBlock(w io.Writer, tmpl, block string, v interface{}) error {
+	if tmpler.TrackUsage {
+		tmpler.used.Store(tmpl, struct{}{})
+	}
+
+	t := tmpler.load()
+
+	if _, ok := t.includes[tmpl]; !ok {
+		err := ErrTemplateNotRegistered{Name: tmpl}
+		tmpler.onRenderFail(w, tmpl, err)
+		return err
+	}
+
+	set := t.set
+	if layout, ok := t.layouts[tmpl]; ok {
+		set = layout.set
+	}
+
+	if tmpler.GlobalData != nil {
+		v = tmpler.withGlobal(nil, v)
+	}
+
+	if err := set.ExecuteTemplate(w, block, v); err != nil {
+		err = t.renderError(tmpl, err)
+		tmpler.onRenderFail(w, tmpl, err)
+		return err
+	}
+
+	return nil
+}
+
+// RenderString executes any subtemplate and returns its output as a string.
+func (tmpler *Templater) RenderString(tmpl string, v interface{}) (string, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	if err := tmpler.Execute(buf, tmpl, v); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// maxPooledBuffer is the capacity above which buffers are not put back into
+// bufferPool, so that a single huge render doesn't keep its memory around.
+const maxPooledBuffer = 1 << 20
+
+// getBuffer returns an empty buffer from bufferPool. The buffer must be given
+// back using putBuffer once its bytes are no longer referenced.
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		bufferPool.Put(buf)
+	}
+}
+
+// execOptions describes how a template is executed.
+type execOptions struct {
+	// ctx, if not nil, is the context that the context functions are bound to.
+	ctx context.Context
+	// raw, if true, skips the PostProcessors and the Minifier.
+	raw bool
+	// depth is the number of "render" and "capture" calls that this execution
+	// is nested in.
+	depth int
+}
+
+func (tmpler *Templater) execute(w io.Writer, tmpl string, v interface{}, opts execOptions) error {
+	if tmpler.TrackUsage {
+		tmpler.used.Store(tmpl, struct{}{})
+	}
+
+	if tmpler.GlobalData != nil && opts.depth == 0 {
+		v = tmpler.withGlobal(opts.ctx, v)
+	}
+
+	if !tmpler.BufferRenders {
+		return tmpler.timeRender(w, tmpl, v, opts)
+	}
+
+	// Buffers can be rendered into directly, since the output can be cut off
+	// again on failure.
+	if buf, ok := w.(*bytes.Buffer); ok {
+		n := buf.Len()
+		err := tmpler.timeRender(buf, tmpl, v, opts)
+		if err != nil {
+			buf.Truncate(n)
+		}
+		return err
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	if err := tmpler.timeRender(buf, tmpl, v, opts); err != nil {
+		return err
+	}
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// timeRender renders the template and reports how long it took to OnRender.
+func (tmpler *Templater) timeRender(w io.Writer, tmpl string, v interface{}, opts execOptions) error {
+	if tmpler.OnRender == nil {
+		return tmpler.renderTimeout(w, tmpl, v, opts)
+	}
+
+	start := time.Now()
+	err := tmpler.renderTimeout(w, tmpl, v, opts)
+	tmpler.OnRender(tmpl, time.Since(start), err)
+
+	return err
+}
+
+// renderTimeout renders the template in another goroutine and gives up once
+// RenderTimeout has passed.
+func (tmpler *Templater) renderTimeout(w io.Writer, tmpl string, v interface{}, opts execOptions) (err error) {
+	if tmpler.RecoverPanics {
+		defer func() {
+			if p := recover(); p != nil {
+				err = tmpler.panicError(tmpl, p)
+			}
+		}()
+	}
+
+	// Load the templates before starting the render goroutine, so that loading
+	// them panics in the caller's goroutine, where it can still be recovered.
+	t := tmpler.load()
+
+	if tmpler.RenderTimeout <= 0 {
+		return tmpler.render(t, w, tmpl, v, opts)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		var err error
+		defer func() {
+			// Nothing can recover a panic in this goroutine, so it's always
+			// returned as an error instead of crashing the program.
+			if p := recover(); p != nil {
+				err = tmpler.panicError(tmpl, p)
+			}
+			pw.CloseWithError(err)
+		}()
+
+		err = tmpler.render(t, pw, tmpl, v, opts)
+	}()
+
+	var timedOut int32
+	timer := time.AfterFunc(tmpler.RenderTimeout, func() {
+		atomic.StoreInt32(&timedOut, 1)
+		// Closing the reader fails the render's next write, which stops it
+		// if it's still writing at all.
+		pr.CloseWithError(ErrRenderTimeout)
+	})
+	defer timer.Stop()
+
+	_, err = io.Copy(w, pr)
+	if atomic.LoadInt32(&timedOut) == 1 {
+		return ErrRenderTimeout
+	}
+	if err != nil {
+		// Stop the render if writing failed.
+		pr.CloseWithError(err)
+	}
+	return err
+}
+
+// panicError returns the panic recovered while rendering the template as an
+// error, logging its stack trace in DebugMode.
+func (tmpler *Templater) panicError(tmpl string, p interface{}) error {
+	if tmpler.debug() {
+		tmpler.logf(slog.LevelError, "panic while rendering %q: %v\n%s", tmpl, p, debug.Stack())
+	}
+	return fmt.Errorf("panic while rendering %s: %v", tmpl, p)
+}
+
+// render renders the template using the given loaded templates, applying the
+// Minifier and the OutputFilters.
+func (tmpler *Templater) render(t *templates, w io.Writer, tmpl string, v interface{}, opts execOptions) (err error) {
+	if _, ok := t.includes[tmpl]; !ok {
+		return ErrTemplateNotRegistered{Name: tmpl}
+	}
+
+	if (opts.ctx != nil || opts.depth > 0) && t.pristine != nil {
+		bound, err := t.bind(opts)
+		if err != nil {
+			return err
+		}
+		defer t.unbind(bound)
+		t = bound
+	}
+
+	// The Minifier and StripHTMLComments depend on the type of the output,
+	// e.g. so that CSS isn't minified as HTML.
+	var mediatype string
+	if !tmpler.TextMode && !opts.raw && !tmpler.debug() {
+		mediatype, _, _ = mime.ParseMediaType(tmpler.contentType(t.includes[tmpl]))
+	}
+
+	strip := tmpler.StripHTMLComments && mediatype == "text/html"
+	minify := tmpler.Minifier != nil && tmpler.minifies(mediatype)
+	filter := len(tmpler.OutputFilters) > 0 && !opts.raw &&
+		!(tmpler.debug() && tmpler.SkipOutputFiltersInDebug)
+
+	if !strip && !minify && !filter {
+		return t.render(w, tmpl, v, opts.raw)
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	if err := t.render(buf, tmpl, v, false); err != nil {
+		return err
+	}
+
+	if strip {
+		stripped := stripHTMLComments(buf.Bytes())
+		buf.Reset()
+		buf.Write(stripped)
+	}
+
+	if !minify && !filter {
+		_, err := buf.WriteTo(w)
+		return err
+	}
+
+	if !filter {
+		return tmpler.Minifier.Minify(mediatype, w, buf)
+	}
+
+	if minify {
+		minified := getBuffer()
+		defer putBuffer(minified)
+
+		if err := tmpler.Minifier.Minify(mediatype, minified, buf); err != nil {
+			return err
+		}
+		buf = minified
+	}
+
+	out := buf.Bytes()
+	for i, filter := range tmpler.OutputFilters {
+		if out, err = filter(out); err != nil {
+			return fmt.Errorf("output filter %d failed in %s: %w", i, tmpl, err)
+		}
+	}
+
+	_, err = w.Write(out)
+	return err
+}
+
+// minifies returns true if the Minifier is used for outputs of the given media
+// type. Refer to MinifyTypes.
+func (tmpler *Templater) minifies(mediatype string) bool {
+	if mediatype == "" {
+		return false
+	}
+
+	types := tmpler.MinifyTypes
+	if types == nil {
+		types = []string{"text/html"}
+	}
+
+	for _, typ := range types {
+		if typ == mediatype {
+			return true
+		}
+	}
+
+	return false
+}
+
+var htmlCommentRe = regexp.MustCompile(`(?s)<!--.*?-->`)
+
+// stripHTMLComments returns the HTML with its comments removed, except for
+// conditional comments.
+func stripHTMLComments(html []byte) []byte {
+	return htmlCommentRe.ReplaceAllFunc(html, func(comment []byte) []byte {
+		if bytes.HasPrefix(comment, []byte("<!--[if")) || bytes.HasPrefix(comment, []byte("<!--<![endif]")) {
+			return comment
+		}
+		return nil
+	})
+}
+
+// contextWriter wraps around a writer to fail all writes once the context is
+// done, which stops the template from rendering any further.
+type contextWriter struct {
+	io.Writer
+	ctx context.Context
+}
+
+func (w contextWriter) Write(b []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.Writer.Write(b)
+}
+
+// Func registers a function; it should only be called before preloading. The
+// function will panic if there's a duplicate function.
+func (tmpler *Templater) Func(name string, fn interface{}) {
+	if _, ok := tmpler.Functions[name]; ok {
+		tmpler.panicln("error: duplicate function with name", name)
+	}
+	if tmpler.Functions == nil {
+		tmpler.Functions = template.FuncMap{}
+	}
+	tmpler.Functions[name] = fn
+}
+
+// Funcs registers all functions in the given map like Func. It panics if any of
+// the functions is already registered, in which case none of them are.
+func (tmpler *Templater) Funcs(fm template.FuncMap) {
+	names := make([]string, 0, len(fm))
+	for name := range fm {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, ok := tmpler.Functions[name]; ok {
+			tmpler.panicln("error: duplicate function with name", name)
+		}
+	}
+
+	for _, name := range names {
+		tmpler.Func(name, fm[name])
+	}
+}
+
+// Delims sets the action delimiters of all templates; it should only be called
+// before preloading. An empty delimiter means the default, "{{" or "}}".
+func (tmpler *Templater) Delims(left, right string) {
+	tmpler.delims = [2]string{left, right}
+}
+
+// Preload preloads the templates once. If the templates are already
+// preloaded, then it does nothing. In DebugMode, templates that reference each
+// other in a cycle are logged.
+func (tmpler *Templater) Preload() {
+	tmpler.Load()
+}
+
+// Ready preloads the templates like Preload, except it returns the error that
+// loading failed with instead of panicking, e.g. for a readiness probe. Once
+// the templates are loaded, it only checks that they still are, so it's cheap
+// to call repeatedly outside of DebugMode.
+func (tmpler *Templater) Ready() (err error) {
+	if !tmpler.debug() && tmpler.loaded() != nil {
+		return nil
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			if perr, ok := v.(error); ok {
+				err = perr
+			} else {
+				err = fmt.Errorf("%v", v)
+			}
+		}
+	}()
+
+	tmpler.load()
+	return nil
+}
+
+// Load loads the templates. If the templates are already loaded, then it does
+// nothing.
+//
+// Pages registered with a layout are not part of the returned template, since
+// each of them lives in its own copy of it. In TextMode, Load loads the
+// templates but returns nil; use LoadText instead.
+func (tmpler *Templater) Load() *template.Template {
+	set, _ := tmpler.load().set.(htmlSet)
+	return set.Template
+}
+
+// LoadText is like Load, except it returns the text/template templates loaded
+// in TextMode. It returns nil if TextMode is false.
+func (tmpler *Templater) LoadText() *texttemplate.Template {
+	set, _ := tmpler.load().set.(textSet)
+	return set.Template
+}
+
+// templates is a set of parsed templates.
+type templates struct {
+	includes   map[string]string // name -> path
+	set        templateSet
+	layouts    map[string]layoutTemplate   // page name -> layout
+	processors map[string]PostProcessor    // name -> processor
+	pageFns    map[string]template.FuncMap // page name -> its own functions
+	// pristine is a copy of the templates that is never executed, so that it
+	// can be cloned to bind the context functions. It is nil if no template
+	// calls any of them.
+	pristine *templates
+	binders  map[string]contextBinder
+	bound    sync.Pool // *templates bound by bind
+	version  string
+	// assetPaths and includedFiles cache the results of the "asset" and
+	// "include" functions, so that they're thrown away on reload.
+	assetPaths    sync.Map // path -> hashed path
+	includedFiles sync.Map // path -> template.HTML
+}
+
+// funcs replaces the functions of all templates.
+func (t *templates) funcs(fm template.FuncMap) {
+	t.set.funcs(fm)
+	for name, layout := range t.layouts {
+		layout.set.funcs(fm)
+		// Functions of the page itself take precedence.
+		if pageFns, ok := t.pageFns[name]; ok {
+			layout.set.funcs(pageFns)
+		}
+	}
+	if t.pristine != nil {
+		t.pristine.funcs(fm)
+	}
+}
+
+func (t *templates) execute(w io.Writer, tmpl string, v interface{}) error {
+	if layout, ok := t.layouts[tmpl]; ok {
+		return layout.set.ExecuteTemplate(w, layout.name, v)
+	}
+
+	return t.set.ExecuteTemplate(w, tmpl, v)
+}
+
+// render executes the template and passes the output through its
+// PostProcessor, unless raw is true.
+func (t *templates) render(w io.Writer, tmpl string, v interface{}, raw bool) error {
+	process, ok := t.processors[tmpl]
+	if !ok || raw {
+		if err := t.execute(w, tmpl, v); err != nil {
+			return t.renderError(tmpl, err)
+		}
+		return nil
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	if err := t.execute(buf, tmpl, v); err != nil {
+		return t.renderError(tmpl, err)
+	}
+
+	return postProcess(process, buf.Bytes(), w)
+}
+
+// renderError prefixes the error with the name and path of the include that
+// failed, which is the include that the error occurred in if known, or the
+// executed one otherwise.
+func (t *templates) renderError(tmpl string, err error) error {
+	var name string
+
+	var execErr texttemplate.ExecError
+	var escapeErr *template.Error
+	switch {
+	case errors.As(err, &execErr):
+		name = execErr.Name
+	case errors.As(err, &escapeErr):
+		name = escapeErr.Name
+	}
+
+	if _, ok := t.includes[name]; ok {
+		tmpl = name
+	}
+
+	return fmt.Errorf("render failed in %s (%s): %w", tmpl, t.includes[tmpl], err)
+}
+
+// layoutTemplate is a page parsed into its own clone of the shared template,
+// with name being the layout to execute.
+type layoutTemplate struct {
+	set  templateSet
+	name string
+}
+
+func (tmpler *Templater) load() *templates {
+	if tmpler.debug() && atomic.LoadInt32(&tmpler.watching) == 0 {
+		tmpler.tmplMu.Lock()
+		defer tmpler.tmplMu.Unlock()
+
+		if !tmpler.DebugModTime {
+			tmpler.sources = nil
+			return tmpler.parse()
+		}
+
+		modTimes := tmpler.statIncludes()
+		if t := tmpler.loaded(); t != nil && modTimes != nil && mapsEqual(modTimes, tmpler.modTimes) {
+			return t
+		}
+
+		tmpler.sources = nil
+		t := tmpler.parse()
+		tmpler.modTimes = modTimes
+		tmpler.tmpl.Store(t)
+		return t
+	}
+
+	if t := tmpler.loaded(); t != nil {
+		return t
+	}
+
+	tmpler.tmplMu.Lock()
+	defer tmpler.tmplMu.Unlock()
+
+	// Check again in case another goroutine has loaded the templates while we
+	// were waiting for the lock.
+	if t := tmpler.loaded(); t != nil {
+		return t
+	}
+
+	t := tmpler.parse()
+	tmpler.tmpl.Store(t)
+	return t
+}
+
+// loaded returns the loaded templates or nil if they're not yet loaded.
+func (tmpler *Templater) loaded() *templates {
+	t, _ := tmpler.tmpl.Load().(*templates)
+	return t
+}
+
+// statIncludes returns the modification times of the files of all includes by
+// path. It returns nil if any of them is unknown.
+func (tmpler *Templater) statIncludes() map[string]time.Time {
+	modTimes := make(map[string]time.Time, len(tmpler.Includes))
+	for name, path := range tmpler.Includes {
+		if _, ok := tmpler.strSrcs[name]; ok {
+			continue
+		}
+		stat, err := fs.Stat(tmpler.FileSystem, path)
+		if err != nil || stat.ModTime().IsZero() {
+			return nil
+		}
+		modTimes[path] = stat.ModTime()
+	}
+	return modTimes
+}
+
+func mapsEqual[K, V comparable](a, b map[K]V) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// parse parses all includes, only reading the ones whose sources aren't
+// cached from the last parse. It panics on errors.
+func (tmpler *Templater) parse() *templates {
+	t, err := tmpler.parseErr()
+	must(err)
+	return t
+}
+
+// parseErr is like parse, except it returns the error instead of panicking.
+func (tmpler *Templater) parseErr() (*templates, error) {
+	unread := make(map[string]string)
+	for name, path := range tmpler.Includes {
+		_, cached := tmpler.sources[name]
+		_, isString := tmpler.strSrcs[name]
+		if !cached && !isString {
+			unread[name] = path
+		}
+	}
+
+	sources, err := readSources(tmpler.FileSystem, unread)
+	if err != nil {
+		return nil, err
+	}
+
+	if tmpler.sources == nil {
+		tmpler.sources = make(map[string]string, len(tmpler.Includes))
+	}
+	for name, src := range sources {
+		tmpler.sources[name] = src
+	}
+	for name, src := range tmpler.strSrcs {
+		if _, ok := tmpler.Includes[name]; ok {
+			tmpler.sources[name] = src
+		}
+	}
+
+	return tmpler.parseSources(func(name string) (string, error) {
+		return tmpler.sources[name], nil
+	})
+}
+
+// readWorkers is the maximum number of files that readSources reads at once.
+const readWorkers = 16
+
+// readSources reads the files of all includes concurrently, since reading may
+// be slow on some filesystems, while parsing must be done one by one anyway.
+func readSources(fsys fs.FS, includes map[string]string) (map[string]string, error) {
+	sources := make(map[string]string, len(includes))
+	var errs []error
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sema := make(chan struct{}, readWorkers)
+
+	for name, path := range includes {
+		wg.Add(1)
+		sema <- struct{}{}
+
+		go func(name, path string) {
+			defer wg.Done()
+			defer func() { <-sema }()
+
+			b, err := fs.ReadFile(fsys, path)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to read file: %w", err))
+				return
+			}
+			sources[name] = string(b)
+		}(name, path)
+	}
+
+	wg.Wait()
+	return sources, errors.Join(errs...)
+}
+
+// parseSources parses all includes, reading the source of each include by its
+// name using the given function.
+func (tmpler *Templater) parseSources(read func(name string) (string, error)) (*templates, error) {
+	parse := func(set templateSet, name string) error {
+		src, err := read(name)
+		if err != nil {
+			return err
+		}
+		return set.parse(name, src)
+	}
+
+	onParse := func(set templateSet, names ...string) error {
+		hs, ok := set.(htmlSet)
+		if !ok || tmpler.OnParse == nil {
+			return nil
+		}
+		for _, name := range names {
+			if err := tmpler.OnParse(name, hs.Lookup(name)); err != nil {
+				return fmt.Errorf("OnParse failed for %s: %w", name, err)
+			}
+		}
+		return nil
+	}
+
+	version := sha256.New()
+	for _, name := range sortedKeys(tmpler.Includes) {
+		src, err := read(name)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(version, "%q %q %q\n", name, tmpler.Includes[name], src)
+	}
+
+	pageFns := tmpler.pageFuncMaps()
+
+	set := tmpler.newTemplateSet()
+	var shared []string
+	for _, name := range sortedKeys(tmpler.Includes) {
+		if _, ok := tmpler.layouts[name]; ok {
+			continue
+		}
+		if _, ok := pageFns[name]; ok {
+			continue
+		}
+		if err := parse(set, name); err != nil {
+			return nil, err
+		}
+		shared = append(shared, name)
+	}
+
+	// Only call OnParse once every shared include is parsed, so that it can
+	// look up any of them.
+	if err := onParse(set, shared...); err != nil {
+		return nil, err
+	}
+
+	layouts := make(map[string]layoutTemplate, len(tmpler.layouts))
+	for name, layout := range tmpler.layouts {
+		if set.tree(layout) == nil {
+			return nil, fmt.Errorf("layout %q of page %q is not registered", layout, name)
+		}
+
+		// Clone the shared template so that the blocks defined by this page
+		// don't clash with the blocks of other pages.
+		page, err := set.clone()
+		if err != nil {
+			return nil, err
+		}
+		if fm, ok := pageFns[name]; ok {
+			page.funcs(fm)
+		}
+		if err := parse(page, name); err != nil {
+			return nil, err
+		}
+		if err := onParse(page, name); err != nil {
+			return nil, err
+		}
+
+		layouts[name] = layoutTemplate{page, layout}
+	}
+
+	// Pages with their own functions are parsed into their own clone like
+	// pages with a layout, except they execute themselves.
+	for name, fm := range pageFns {
+		if _, ok := layouts[name]; ok {
+			continue
+		}
+		if _, ok := tmpler.Includes[name]; !ok {
+			continue
+		}
+
+		page, err := set.clone()
+		if err != nil {
+			return nil, err
+		}
+		page.funcs(fm)
+		if err := parse(page, name); err != nil {
+			return nil, err
+		}
+		if err := onParse(page, name); err != nil {
+			return nil, err
+		}
+
+		layouts[name] = layoutTemplate{page, name}
+	}
+
+	processors := make(map[string]PostProcessor)
+	for name, incl := range tmpler.Includes {
+		if process, ok := tmpler.PostProcessors[filepath.Ext(incl)]; ok {
+			if tmpler.PostProcessorFallback {
+				process = tmpler.rawFallback(name, process)
+			}
+			processors[name] = process
+		}
+	}
+
+	t := &templates{
+		includes:   copyMap(tmpler.Includes),
+		set:        set,
+		layouts:    layouts,
+		processors: processors,
+		pageFns:    pageFns,
+		version:    hex.EncodeToString(version.Sum(nil)[:8]),
+	}
+
+	if tmpler.StrictReferences {
+		if err := checkRefs(t); err != nil {
+			return nil, err
+		}
+	}
+
+	// Only functions that are called need to be bound, so that executions
+	// don't pay for copying the templates otherwise.
+	if binders := usedBinders(tmpler.contextBinders(), t); len(binders) > 0 {
+		pristine, err := t.clone()
+		if err != nil {
+			return nil, err
+		}
+		t.pristine = pristine
+		t.binders = binders
+	}
+
+	if tmpler.debug() {
+		tmpler.logCycles(t)
+	}
+
+	return t, nil
+}
+
+// must panics if err is not nil, like template.Must.
+func must(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Reload reads and parses all includes again and swaps the new templates in
+// atomically, e.g. after deploying new templates without restarting. Unlike
+// Reset, if the new templates fail to load, then the error is returned and the
+// current templates keep being used. Executions that are already running keep
+// using the old templates.
+func (tmpler *Templater) Reload() error {
+	tmpler.tmplMu.Lock()
+	defer tmpler.tmplMu.Unlock()
+
+	return tmpler.reload()
+}
+
+func (tmpler *Templater) reload() error {
+	tmpler.sources = nil
+
+	t, err := tmpler.parseErr()
+	if err != nil {
+		return fmt.Errorf("failed to reload: %w", err)
+	}
+
+	tmpler.tmpl.Store(t)
+	return nil
+}
+
+// Reset resets the template to its initial state.
+func (tmpler *Templater) Reset() {
+	tmpler.tmplMu.Lock()
+	defer tmpler.tmplMu.Unlock()
+
+	tmpler.sources = nil
+	tmpler.tmpl.Store((*templates)(nil))
+}
+
+// Invalidate resets the template like Reset, except only the files of the
+// given includes are read again on the next load, while the sources of the
+// other includes are reused from the last load. All templates are still parsed
+// again, since parsed templates cannot be partially replaced, but this saves
+// reading every file when only a few have changed.
+func (tmpler *Templater) Invalidate(names ...string) {
+	tmpler.tmplMu.Lock()
+	defer tmpler.tmplMu.Unlock()
+
+	for _, name := range names {
+		delete(tmpler.sources, name)
+	}
+	tmpler.tmpl.Store((*templates)(nil))
+}
+
+// Subtemplate describes a subtemplate that belongs to some parent template.
+type Subtemplate struct {
+	tmpl *Templater
+	name string
+}
+
+// Templater gets the parent templater of the subtemplate.
+func (sub *Subtemplate) Templater() *Templater {
+	return sub.tmpl
+}
+
+// Name gets the subtemplate's name.
+func (sub *Subtemplate) Name() string {
+	return sub.name
+}
+
+// Exists returns true if the subtemplate is registered. Refer to Has.
+func (sub *Subtemplate) Exists() bool {
+	return sub.tmpl.Has(sub.name)
+}
+
+// Execute executes the subtemplate.
+func (sub *Subtemplate) Execute(w io.Writer, v interface{}) error {
+	return sub.tmpl.Execute(w, sub.name, v)
+}
+
+// ExecuteContext executes the subtemplate with the given context. Refer to
+// Templater.ExecuteContext.
+func (sub *Subtemplate) ExecuteContext(ctx context.Context, w io.Writer, v interface{}) error {
+	return sub.tmpl.ExecuteContext(ctx, w, sub.name, v)
+}
+
+// ExecuteRaw executes the subtemplate without its PostProcessors. Refer to
+// Templater.ExecuteRaw.
+func (sub *Subtemplate) ExecuteRaw(w io.Writer, v interface{}) error {
+	return sub.tmpl.ExecuteRaw(w, sub.name, v)
+}
+
+// ExecuteLocalized executes the localized variant of the subtemplate for the
+// given locale. Refer to Templater.ExecuteLocalized.
+func (sub *Subtemplate) ExecuteLocalized(w io.Writer, locale string, v interface{}) error {
+	return sub.tmpl.ExecuteLocalized(w, sub.name, locale, v)
+}
+
+// ExecuteBlock executes only the block with the given name defined within the
+// subtemplate. Refer to Templater.ExecuteBlock.
+func (sub *Subtemplate) ExecuteBlock(w io.Writer, block string, v interface{}) error {
+	return sub.tmpl.ExecuteBlock(w, sub.name, block, v)
+}
+
+// RenderString executes the subtemplate and returns its output as a string.
+func (sub *Subtemplate) RenderString(v interface{}) (string, error) {
+	return sub.tmpl.RenderString(sub.name, v)
+}
+
+// ExecuteToBuffer executes the subtemplate and appends its output to the given
+// buffer, which is not reset, e.g. to build an email out of multiple
+// subtemplates. Unlike RenderString, the output is written into the buffer
+// directly without being copied. If rendering fails, then its partial output
+// is removed from the buffer before OnRenderFail is called.
+func (sub *Subtemplate) ExecuteToBuffer(buf *bytes.Buffer, v interface{}) error {
+	n := buf.Len()
+	if err := sub.tmpl.execute(buf, sub.name, v, execOptions{}); err != nil {
+		buf.Truncate(n)
+		sub.tmpl.onRenderFail(buf, sub.name, err)
+		return err
+	}
+	return nil
+}
+
+// WriteTo executes the subtemplate and returns the number of bytes written to
+// w, which is the size of the final output after the PostProcessors and the
+// Minifier, e.g. for logging the response size.
+func (sub *Subtemplate) WriteTo(w io.Writer, v interface{}) (int64, error) {
+	cw := countingWriter{Writer: w}
+	err := sub.Execute(&cw, v)
+	return cw.n, err
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.Writer.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+// MustSubFS forces creation of a sub-filesystem using fs.Sub. It panics on
+// errors.
+func MustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(fmt.Sprint(err))
+	}
+	return sub
 }
 
 // AlwaysFlush is the middleware to always flush after a write.
@@ -293,3 +2098,23 @@ func (f flushWriter) Write(b []byte) (int, error) {
 	f.flusher.Flush()
 	return n, nil
 }
+
+// cleanPath replaces backslashes in the path with forward slashes and cleans
+// it. It returns an error if the path is not valid according to fs.ValidPath.
+func cleanPath(p string) (string, error) {
+	p = path.Clean(strings.ReplaceAll(p, `\`, "/"))
+	if !fs.ValidPath(p) {
+		return p, fmt.Errorf("invalid path %q", p)
+	}
+	return p, nil
+}
+
+// sortedKeys returns the keys of the map in sorted order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
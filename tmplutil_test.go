@@ -0,0 +1,1499 @@
+package tmplutil
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// newTestTemplater returns a Templater with the given files, where every file
+// is registered under its path without the extension.
+func newTestTemplater(t testing.TB, files map[string]string) *Templater {
+	t.Helper()
+
+	fsys := make(fstest.MapFS, len(files))
+	for path, src := range files {
+		fsys[path] = &fstest.MapFile{Data: []byte(src)}
+	}
+
+	tmpler := NewTemplater(fsys)
+	if err := tmpler.PreregisterFunc(func(p string) (string, bool) {
+		return strings.TrimSuffix(p, path.Ext(p)), true
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return tmpler
+}
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func mustRender(t testing.TB, tmpler *Templater, name string, v interface{}) string {
+	t.Helper()
+
+	out, err := tmpler.RenderString(name, v)
+	if err != nil {
+		t.Fatalf("failed to render %q: %v", name, err)
+	}
+	return out
+}
+
+func TestRenderTimeout(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"slow.html": `before {{ sleep }} after`,
+	})
+	tmpler.Functions["sleep"] = func() string {
+		time.Sleep(200 * time.Millisecond)
+		return ""
+	}
+	tmpler.RenderTimeout = 10 * time.Millisecond
+
+	err := tmpler.Execute(io.Discard, "slow", nil)
+	if !errors.Is(err, ErrRenderTimeout) {
+		t.Fatalf("expected ErrRenderTimeout, got %v", err)
+	}
+}
+
+func TestRenderTimeoutPanic(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"page.css": `body {}`,
+	})
+	tmpler.PostProcessors = map[string]PostProcessor{
+		".css": func(in []byte, w io.Writer) error { panic("boom") },
+	}
+	tmpler.RenderTimeout = time.Second
+
+	err := tmpler.Execute(io.Discard, "page", nil)
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expected the panic as an error, got %v", err)
+	}
+}
+
+func TestRenderTimeoutDebugParseError(t *testing.T) {
+	debug := true
+
+	tmpler := newTestTemplater(t, map[string]string{
+		"broken.html": `{{ if }}`,
+	})
+	tmpler.Debug = &debug
+	tmpler.Logger = discardLogger
+	tmpler.RenderTimeout = time.Second
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected loading to panic in the calling goroutine")
+		}
+	}()
+
+	tmpler.Execute(io.Discard, "broken", nil)
+}
+
+func TestRenderTimeoutRecoverPanics(t *testing.T) {
+	debug := true
+
+	tmpler := newTestTemplater(t, map[string]string{
+		"broken.html": `{{ if }}`,
+	})
+	tmpler.Debug = &debug
+	tmpler.Logger = discardLogger
+	tmpler.RenderTimeout = time.Second
+	tmpler.RecoverPanics = true
+
+	if err := tmpler.Execute(io.Discard, "broken", nil); err == nil {
+		t.Fatal("expected the parse error to be returned")
+	}
+}
+
+func TestOverrideAndReload(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"page.html": `<p>{{ include "icon.svg" }}</p>`,
+		"icon.svg":  `OLD`,
+	})
+
+	if out := mustRender(t, tmpler, "page", nil); out != "<p>OLD</p>" {
+		t.Fatalf("unexpected output %q", out)
+	}
+
+	theme := fstest.MapFS{
+		"icon.svg": &fstest.MapFile{Data: []byte("NEW")},
+	}
+	if err := tmpler.OverrideAndReload(theme); err != nil {
+		t.Fatal(err)
+	}
+
+	if out := mustRender(t, tmpler, "page", nil); out != "<p>NEW</p>" {
+		t.Errorf("expected the override to be used, got %q", out)
+	}
+
+	broken := fstest.MapFS{
+		"page.html": &fstest.MapFile{Data: []byte("{{ if }}")},
+	}
+	if err := tmpler.OverrideAndReload(broken); err == nil {
+		t.Fatal("expected a broken override to fail")
+	}
+
+	if out := mustRender(t, tmpler, "page", nil); out != "<p>NEW</p>" {
+		t.Errorf("expected the previous templates to be kept, got %q", out)
+	}
+}
+
+func TestMinifier(t *testing.T) {
+	minifier := MinifierFunc(func(mediatype string, w io.Writer, r io.Reader) error {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, mediatype+":"+strings.Join(strings.Fields(string(b)), " "))
+		return err
+	})
+
+	tests := []struct {
+		name  string
+		types []string
+		tmpl  string
+		out   string
+	}{
+		{"html", nil, "page", "text/html:<p> hi </p>"},
+		{"css not minified by default", nil, "style", "body  {  }"},
+		{"text not minified by default", nil, "notes", "a  b"},
+		{"css in MinifyTypes", []string{"text/html", "text/css"}, "style", "text/css:body { }"},
+		{"html not in MinifyTypes", []string{"text/css"}, "page", "<p>  hi  </p>"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpler := newTestTemplater(t, map[string]string{
+				"page.html": `<p>  hi  </p>`,
+				"style.css": `body  {  }`,
+				"notes.txt": `a  b`,
+			})
+			tmpler.Minifier = minifier
+			tmpler.MinifyTypes = test.types
+
+			if out := mustRender(t, tmpler, test.tmpl, nil); out != test.out {
+				t.Errorf("expected %q, got %q", test.out, out)
+			}
+		})
+	}
+}
+
+func TestMinifierStripsComments(t *testing.T) {
+	// Like github.com/tdewolff/minify, which removes HTML comments.
+	comment := regexp.MustCompile(`(?s)<!--.*?-->`)
+	minifier := MinifierFunc(func(mediatype string, w io.Writer, r io.Reader) error {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(comment.ReplaceAll(b, nil))
+		return err
+	})
+
+	tmpler := newTestTemplater(t, map[string]string{
+		"page.html": `<p>{{ . }}</p>`,
+		"post.md":   `# {{ . }}`,
+	})
+	tmpler.PostProcessors = map[string]PostProcessor{
+		".md": func(in []byte, w io.Writer) error {
+			io.WriteString(w, "<!-- generated -->")
+			return upperMarkdown(in, w)
+		},
+	}
+	tmpler.Minifier = minifier
+
+	// html/template already removes comments written in templates, but not
+	// ones from template.HTML values or PostProcessors.
+	if out := mustRender(t, tmpler, "page", template.HTML("<!-- secret -->hi")); out != "<p>hi</p>" {
+		t.Errorf("expected the comment to be stripped, got %q", out)
+	}
+	if out := mustRender(t, tmpler, "post", "Title"); out != "<h1>Title</h1>" {
+		t.Errorf("expected the post-processed comment to be stripped, got %q", out)
+	}
+}
+
+func TestStripHTMLComments(t *testing.T) {
+	const comments = "<!-- secret --><!--[if IE]>old<![endif]-->"
+
+	tests := []struct {
+		name     string
+		tmpl     string
+		textMode bool
+		out      string
+	}{
+		{"html", "page", false, "<p>hi</p><!--[if IE]>old<![endif]-->"},
+		{"css", "style", false, "a {} " + comments},
+		{"text mode", "page", true, "<p>hi</p>" + comments},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpler := newTestTemplater(t, map[string]string{
+				"page.html": `<p>hi</p>{{ . }}`,
+				"style.css": `a {} {{ . }}`,
+			})
+			tmpler.TextMode = test.textMode
+			tmpler.StripHTMLComments = true
+
+			var data interface{} = template.HTML(comments)
+			if test.textMode {
+				data = comments
+			}
+
+			if out := mustRender(t, tmpler, test.tmpl, data); out != test.out {
+				t.Errorf("expected %q, got %q", test.out, out)
+			}
+		})
+	}
+}
+
+func TestOnParse(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"a.html":      `{{ template "b" . }}`,
+		"b.html":      `<p>{{ . }}</p>`,
+		"layout.html": `<main>{{ block "content" . }}{{ end }}</main>`,
+	})
+	tmpler.RegisterWithLayout("page", "page.html", "layout")
+	tmpler.FileSystem.(fstest.MapFS)["page.html"] = &fstest.MapFile{
+		Data: []byte(`{{ define "content" }}page{{ end }}`),
+	}
+
+	parsed := make(map[string]int)
+	tmpler.OnParse = func(name string, tmpl *template.Template) error {
+		parsed[name]++
+
+		// Every shared include can be looked up, regardless of the order
+		// they're parsed in.
+		for _, other := range []string{"a", "b", "layout"} {
+			if tmpl.Lookup(other) == nil {
+				return fmt.Errorf("%q cannot look up %q", name, other)
+			}
+		}
+		return nil
+	}
+
+	if err := tmpler.Ready(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a", "b", "layout", "page"} {
+		if parsed[name] != 1 {
+			t.Errorf("expected OnParse to be called once for %q, got %d", name, parsed[name])
+		}
+	}
+}
+
+func TestOnParseError(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"page.html": `<form></form>`,
+	})
+	tmpler.OnParse = func(name string, tmpl *template.Template) error {
+		return errors.New("rejected")
+	}
+
+	if err := tmpler.Ready(); err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Errorf("expected OnParse's error, got %v", err)
+	}
+}
+
+func TestPreregisterExtensions(t *testing.T) {
+	fsys := fstest.MapFS{
+		"pages/index.gohtml": &fstest.MapFile{Data: []byte(`index`)},
+		"pages/about.tmpl":   &fstest.MapFile{Data: []byte(`about`)},
+		"pages/old.html":     &fstest.MapFile{Data: []byte(`old`)},
+	}
+
+	tmpler := NewTemplater(fsys)
+	tmpler.Extensions = []string{".gohtml", ".tmpl"}
+	if err := tmpler.Preregister(); err != nil {
+		t.Fatal(err)
+	}
+
+	names := strings.Join(tmpler.RegisteredNames(), ",")
+	if names != "about,index" {
+		t.Errorf("expected only the .gohtml and .tmpl files, got %q", names)
+	}
+
+	if out := mustRender(t, tmpler, "index", nil); out != "index" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+func TestRegisterSafe(t *testing.T) {
+	files := map[string]string{
+		"page.html": `<p>{{ . }}</p>`,
+	}
+	for i := 0; i < 20; i++ {
+		files[fmt.Sprintf("late/%d.html", i)] = fmt.Sprintf(`late %d`, i)
+	}
+
+	tmpler := NewTemplater(newTestTemplater(t, files).FileSystem)
+	tmpler.Register("page", "page.html")
+	tmpler.Preload()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				out, err := tmpler.RenderString("page", "hi")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if out != "<p>hi</p>" {
+					t.Errorf("unexpected output %q", out)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("late%d", i)
+		tmpler.RegisterSafe(name, fmt.Sprintf("late/%d.html", i))
+
+		if out := mustRender(t, tmpler, name, nil); out != fmt.Sprintf("late %d", i) {
+			t.Errorf("unexpected output %q", out)
+		}
+	}
+
+	close(done)
+	wg.Wait()
+}
+
+func newBenchTemplater(b *testing.B) *Templater {
+	tmpler := newTestTemplater(b, map[string]string{
+		"page.html": `<ul>{{ range . }}<li>{{ . }}</li>{{ end }}</ul>`,
+	})
+	tmpler.Preload()
+	return tmpler
+}
+
+var benchItems = []string{"alpha", "beta", "gamma", "delta", "epsilon"}
+
+func BenchmarkRenderString(b *testing.B) {
+	tmpler := newBenchTemplater(b)
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := tmpler.RenderString("page", benchItems); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkRenderStringNaive renders into a new buffer every time for
+// comparison with BenchmarkRenderString.
+func BenchmarkRenderStringNaive(b *testing.B) {
+	tmpler := newBenchTemplater(b)
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		var buf bytes.Buffer
+		if err := tmpler.Execute(&buf, "page", benchItems); err != nil {
+			b.Fatal(err)
+		}
+		_ = buf.String()
+	}
+}
+
+func TestDelims(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"page.html":  `<p><< .Name >></p> {{ not an action }} << template "inner" . >>`,
+		"inner.html": `<b><< .Name >></b>`,
+	})
+	tmpler.Delims("<<", ">>")
+
+	out := mustRender(t, tmpler, "page", map[string]string{"Name": "alice"})
+	if out != "<p>alice</p> {{ not an action }} <b>alice</b>" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+// blogPost returns a markdown include about the size of a typical blog post.
+func blogPost() string {
+	var b strings.Builder
+	b.WriteString("# {{ .Title }}\n")
+	for i := 0; i < 40; i++ {
+		b.WriteString("Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt ut labore et dolore magna aliqua.\n")
+		if i%10 == 0 {
+			b.WriteString("# Section {{ .Title }}\n")
+		}
+	}
+	return b.String()
+}
+
+func BenchmarkPostProcessBlogPost(b *testing.B) {
+	tmpler := newTestTemplater(b, map[string]string{
+		"post.md": blogPost(),
+	})
+	tmpler.PostProcessors = map[string]PostProcessor{".md": upperMarkdown}
+	tmpler.Preload()
+
+	data := map[string]string{"Title": "Hello"}
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if err := tmpler.Execute(io.Discard, "post", data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestTextMode(t *testing.T) {
+	tests := []struct {
+		textMode bool
+		out      string
+	}{
+		{true, `Hello <b>"alice" & co</b> 1 < 2`},
+		{false, `Hello &lt;b&gt;&#34;alice&#34; &amp; co&lt;/b&gt; 1 &lt; 2`},
+	}
+
+	for _, test := range tests {
+		tmpler := newTestTemplater(t, map[string]string{
+			"email.txt": `Hello {{ . }} 1 < 2`,
+		})
+		tmpler.TextMode = test.textMode
+
+		if out := mustRender(t, tmpler, "email", `<b>"alice" & co</b>`); out != test.out {
+			t.Errorf("TextMode %v: expected %q, got %q", test.textMode, test.out, out)
+		}
+	}
+
+	tmpler := newTestTemplater(t, map[string]string{"email.txt": `{{ . }}`})
+	tmpler.TextMode = true
+
+	if tmpler.Load() != nil || tmpler.LoadText() == nil {
+		t.Error("expected only LoadText to return the templates in TextMode")
+	}
+}
+
+func TestRegisteredNames(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"b.html":       `b`,
+		"a.html":       `a`,
+		"nested/c.htm": `c`,
+	})
+
+	if names := strings.Join(tmpler.RegisteredNames(), ","); names != "a,b,nested/c" {
+		t.Errorf("unexpected names %q", names)
+	}
+
+	if path, ok := tmpler.Lookup("nested/c"); !ok || path != "nested/c.htm" {
+		t.Errorf("unexpected lookup %q, %v", path, ok)
+	}
+	if _, ok := tmpler.Lookup("missing"); ok {
+		t.Error("expected a missing name not to be found")
+	}
+}
+
+func TestExecuteBlock(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"layout.html": `<main>{{ block "content" . }}No content.{{ end }}</main>`,
+		"list.html":   `{{ define "items" }}<li>{{ . }}</li>{{ end }}`,
+	})
+	tmpler.FileSystem.(fstest.MapFS)["page.html"] = &fstest.MapFile{
+		Data: []byte(`{{ define "content" }}<h1>{{ .Title }}</h1>{{ block "count" . }}{{ .Count }} items{{ end }}{{ end }}`),
+	}
+	tmpler.RegisterWithLayout("page", "page.html", "layout")
+
+	data := map[string]interface{}{"Title": "Inbox", "Count": 3}
+
+	if out := mustRender(t, tmpler, "page", data); out != "<main><h1>Inbox</h1>3 items</main>" {
+		t.Errorf("unexpected full page %q", out)
+	}
+
+	tests := []struct {
+		tmpl  string
+		block string
+		out   string
+	}{
+		{"page", "content", "<h1>Inbox</h1>3 items"},
+		{"page", "count", "3 items"},
+		{"list", "items", "<li>Inbox</li>"},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		v := interface{}(data)
+		if test.tmpl == "list" {
+			v = "Inbox"
+		}
+		if err := tmpler.ExecuteBlock(&buf, test.tmpl, test.block, v); err != nil {
+			t.Errorf("failed to execute %s/%s: %v", test.tmpl, test.block, err)
+			continue
+		}
+		if out := buf.String(); out != test.out {
+			t.Errorf("%s/%s: expected %q, got %q", test.tmpl, test.block, test.out, out)
+		}
+	}
+
+	if err := tmpler.ExecuteBlock(io.Discard, "page", "missing", data); err == nil {
+		t.Error("expected a missing block to fail")
+	}
+}
+
+//go:embed testdata/templates
+var testTemplates embed.FS
+
+func ExampleFromEmbed() {
+	tmpler := FromEmbed(testTemplates, "testdata/templates")
+
+	if err := tmpler.Execute(os.Stdout, "hello", "world"); err != nil {
+		fmt.Println(err)
+	}
+
+	// Output:
+	// <h1>Hello, world!</h1><footer>bye</footer>
+}
+
+func TestZeroTemplater(t *testing.T) {
+	var tmpler Templater
+	tmpler.FileSystem = mapFS(map[string]string{
+		"page.html": `<p>{{ shout . }}</p>`,
+	})
+
+	tmpler.Func("shout", strings.ToUpper)
+	tmpler.Register("page", "page.html")
+
+	if out := mustRender(t, &tmpler, "page", "hi"); out != "<p>HI</p>" {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	var preregistered Templater
+	preregistered.FileSystem = tmpler.FileSystem
+	if err := preregistered.Preregister(); err != nil {
+		t.Fatal(err)
+	}
+	if !preregistered.Has("page") {
+		t.Error("expected Preregister to register the page")
+	}
+}
+
+func TestStrictNames(t *testing.T) {
+	fsys := mapFS(map[string]string{
+		"blog/index.html": `blog`,
+		"docs/index.html": `docs`,
+		"about.html":      `about`,
+	})
+
+	for _, strict := range []bool{false, true} {
+		tmpler := NewTemplater(fsys)
+		tmpler.StrictNames = strict
+
+		err := tmpler.Preregister()
+		if strict {
+			if err == nil || !strings.Contains(err.Error(), `"index" (blog/index.html, docs/index.html)`) {
+				t.Errorf("expected the collision to be reported, got %v", err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("expected the collision to be ignored, got %v", err)
+		}
+		// The first file found wins.
+		if path, _ := tmpler.Lookup("index"); path != "blog/index.html" {
+			t.Errorf("expected blog/index.html to win, got %q", path)
+		}
+	}
+}
+
+func TestPreregisterPaths(t *testing.T) {
+	tmpler := NewTemplater(mapFS(map[string]string{
+		"users/list.html": `users`,
+		"posts/list.html": `posts`,
+	}))
+	tmpler.StrictNames = true
+
+	if err := tmpler.PreregisterPaths(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expect := range map[string]string{
+		"users/list": "users",
+		"posts/list": "posts",
+	} {
+		if out := mustRender(t, tmpler, name, nil); out != expect {
+			t.Errorf("%s: expected %q, got %q", name, expect, out)
+		}
+	}
+}
+
+func TestExecuteLocalized(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"greeting.html":    `Hi`,
+		"greeting.en.html": `Hello`,
+		"greeting.fr.html": `Bonjour`,
+	})
+
+	tests := map[string]string{
+		"en":    "Hello",
+		"en-GB": "Hello",
+		"fr_CA": "Bonjour",
+		"de":    "Hi",
+		"":      "Hi",
+	}
+
+	for locale, expect := range tests {
+		var buf strings.Builder
+		if err := tmpler.ExecuteLocalized(&buf, "greeting", locale, nil); err != nil {
+			t.Errorf("%q: %v", locale, err)
+			continue
+		}
+		if buf.String() != expect {
+			t.Errorf("%q: expected %q, got %q", locale, expect, buf.String())
+		}
+	}
+}
+
+func TestMissingKey(t *testing.T) {
+	data := map[string]int{"present": 1}
+
+	tests := []struct {
+		mode     string
+		textMode bool
+		expect   string
+		err      bool
+	}{
+		{mode: "", expect: "1,"},
+		{mode: "default", textMode: true, expect: "1,<no value>"},
+		{mode: "zero", expect: "1,0"},
+		{mode: "error", err: true},
+	}
+
+	for _, test := range tests {
+		tmpler := newTestTemplater(t, map[string]string{
+			"page.html": `{{ .present }},{{ .missing }}`,
+		})
+		tmpler.MissingKey = test.mode
+		tmpler.TextMode = test.textMode
+
+		var buf strings.Builder
+		err := tmpler.Execute(&buf, "page", data)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %q", test.mode, buf.String())
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: %v", test.mode, err)
+		} else if buf.String() != test.expect {
+			t.Errorf("%q: expected %q, got %q", test.mode, test.expect, buf.String())
+		}
+	}
+}
+
+func TestRenderErrorPath(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"page.html":          `<main>{{ template "partials/card" . }}</main>`,
+		"partials/card.html": `{{ fail }}`,
+	})
+	tmpler.Functions["fail"] = func() (string, error) { return "", errors.New("failed") }
+
+	err := tmpler.Execute(io.Discard, "page", nil)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// The error names the partial that failed, not the executed page.
+	if !strings.Contains(err.Error(), "render failed in partials/card (partials/card.html)") {
+		t.Errorf("expected the failing file in the error, got %v", err)
+	}
+}
+
+// newManyTemplater returns a Templater with n pages that each include a
+// shared partial.
+func newManyTemplater(b *testing.B, n int) *Templater {
+	files := map[string]string{
+		"partial.html": `<footer>{{ . }}</footer>`,
+	}
+	for i := 0; i < n; i++ {
+		files[fmt.Sprintf("page%d.html", i)] = fmt.Sprintf(`<p>%d</p>{{ template "partial" . }}`, i)
+	}
+	return newTestTemplater(b, files)
+}
+
+func BenchmarkReload(b *testing.B) {
+	tmpler := newManyTemplater(b, 200)
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if err := tmpler.Reload(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestBufferRenders(t *testing.T) {
+	for _, buffered := range []bool{false, true} {
+		tmpler := newTestTemplater(t, map[string]string{
+			"page.html": `<p>half a page</p>{{ fail }}`,
+		})
+		tmpler.Functions["fail"] = func() (string, error) { return "", errors.New("failed") }
+		tmpler.OnRenderFail = func(sub *Subtemplate, w io.Writer, err error) {
+			io.WriteString(w, "error page")
+		}
+		tmpler.BufferRenders = buffered
+
+		// Not a *bytes.Buffer, which is written into directly.
+		var out strings.Builder
+		if err := tmpler.Execute(&out, "page", nil); err == nil {
+			t.Fatal("expected an error")
+		}
+
+		expect := "<p>half a page</p>error page"
+		if buffered {
+			expect = "error page"
+		}
+		if out.String() != expect {
+			t.Errorf("buffered=%v: expected %q, got %q", buffered, expect, out.String())
+		}
+	}
+}
+
+// recordHandler is a slog.Handler that records every message logged to it.
+type recordHandler struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (h *recordHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *recordHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *recordHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *recordHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	h.messages = append(h.messages, r.Level.String()+" "+r.Message)
+	h.mu.Unlock()
+	return nil
+}
+
+func TestLogger(t *testing.T) {
+	debug := true
+
+	tmpler := newTestTemplater(t, map[string]string{
+		"page.html": `{{ fail }}`,
+	})
+	tmpler.Functions["fail"] = func() (string, error) { return "", errors.New("failed") }
+	tmpler.Debug = &debug
+
+	h := &recordHandler{}
+	tmpler.Logger = slog.New(h)
+
+	if err := tmpler.Execute(io.Discard, "page", nil); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, msg := range h.messages {
+		if strings.HasPrefix(msg, `ERROR failed to render "page"`) {
+			return
+		}
+	}
+	t.Errorf("expected the render failure to be logged, got %q", h.messages)
+}
+
+func TestPreregisterErr(t *testing.T) {
+	tmpler := NewTemplater(errorFS{fs.ErrPermission})
+
+	_, err := PreregisterErr(tmpler)
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected the walk error, got %v", err)
+	}
+}
+
+func TestPostProcessorFallback(t *testing.T) {
+	for _, fallback := range []bool{false, true} {
+		tmpler := newTestTemplater(t, map[string]string{
+			"post.md": `# {{ . }}`,
+		})
+		tmpler.Logger = discardLogger
+		tmpler.PostProcessors = map[string]PostProcessor{
+			".md": func(in []byte, w io.Writer) error { return errors.New("bad markdown") },
+		}
+		tmpler.PostProcessorFallback = fallback
+
+		out, err := tmpler.RenderString("post", "Title")
+		if !fallback {
+			if err == nil {
+				t.Errorf("expected the error without a fallback, got %q", out)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != "<pre># Title</pre>" {
+			t.Errorf("unexpected fallback output %q", out)
+		}
+	}
+}
+
+func BenchmarkInvalidate(b *testing.B) {
+	tmpler := newManyTemplater(b, 100)
+	tmpler.Preload()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		tmpler.Invalidate("page0")
+		tmpler.Preload()
+	}
+}
+
+func TestHas(t *testing.T) {
+	tmpler := NewTemplater(mapFS(map[string]string{
+		"page.html":        `page`,
+		"theme/extra.html": `extra`,
+	}))
+	tmpler.Register("page", "page.html")
+
+	if !tmpler.Has("page") || !tmpler.Subtemplate("page").Exists() {
+		t.Error("expected the registered page to exist")
+	}
+	if tmpler.Has("extra") || tmpler.Subtemplate("extra").Exists() {
+		t.Error("expected the unregistered page not to exist")
+	}
+
+	if err := tmpler.Preregister("theme"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !tmpler.Has("extra") || !tmpler.Subtemplate("extra").Exists() {
+		t.Error("expected the preregistered page to exist")
+	}
+}
+
+func TestVersion(t *testing.T) {
+	fsys := mapFS(map[string]string{
+		"page.html": `first`,
+	})
+	tmpler := NewTemplater(fsys)
+	tmpler.Register("page", "page.html")
+
+	first := tmpler.Version()
+	if first == "" {
+		t.Fatal("expected a version")
+	}
+	if again := tmpler.Version(); again != first {
+		t.Errorf("expected the version to be stable, got %q and %q", first, again)
+	}
+
+	fsys["page.html"] = &fstest.MapFile{Data: []byte(`second`)}
+	if err := tmpler.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	second := tmpler.Version()
+	if second == first {
+		t.Errorf("expected the version to change after Reload, got %q", second)
+	}
+
+	if err := tmpler.OverrideAndReload(mapFS(map[string]string{"page.html": `third`})); err != nil {
+		t.Fatal(err)
+	}
+
+	third := tmpler.Version()
+	if third == second || third == first {
+		t.Errorf("expected the version to change after OverrideAndReload, got %q", third)
+	}
+
+	rec := httptest.NewRecorder()
+	tmpler.VersionMiddleware(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if v := rec.Header().Get("X-Template-Version"); v != third {
+		t.Errorf("expected the X-Template-Version header to be %q, got %q", third, v)
+	}
+}
+
+func TestExecuteRaw(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"post.md": "# {{ . }}\n\nBody",
+	})
+	tmpler.PostProcessors = map[string]PostProcessor{".md": upperMarkdown}
+
+	if out := mustRender(t, tmpler, "post", "Title"); out != "<h1>Title</h1><p></p><p>Body</p>" {
+		t.Errorf("unexpected rendered output %q", out)
+	}
+
+	var buf strings.Builder
+	if err := tmpler.ExecuteRaw(&buf, "post", "Title"); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "# Title\n\nBody" {
+		t.Errorf("expected the templated markdown, got %q", buf.String())
+	}
+}
+
+// flushRecorder records the length of the output at every flush.
+type flushRecorder struct {
+	strings.Builder
+	flushes []int
+}
+
+func (w *flushRecorder) Flush() { w.flushes = append(w.flushes, w.Len()) }
+
+func TestStream(t *testing.T) {
+	const n = 10000
+
+	tmpler := newTestTemplater(t, map[string]string{
+		"header.html": `<ul>`,
+		"row.html":    `<li>{{ . }}</li>`,
+		"footer.html": `</ul>`,
+	})
+
+	items := make(chan interface{})
+	go func() {
+		defer close(items)
+		for i := 0; i < n; i++ {
+			items <- i
+		}
+	}()
+
+	var w flushRecorder
+	if err := tmpler.Stream(&w, "header", "row", "footer", items); err != nil {
+		t.Fatal(err)
+	}
+
+	if out := w.String(); !strings.HasPrefix(out, "<ul><li>0</li><li>1</li>") || !strings.HasSuffix(out, "<li>9999</li></ul>") {
+		t.Errorf("unexpected output %q...", out[:50])
+	}
+
+	// The header, every row and the footer are flushed as they're written.
+	if len(w.flushes) != n+2 {
+		t.Fatalf("expected %d flushes, got %d", n+2, len(w.flushes))
+	}
+	if w.flushes[0] != len("<ul>") || w.flushes[1] != len("<ul><li>0</li>") {
+		t.Errorf("expected the first rows to be flushed right away, got %v", w.flushes[:2])
+	}
+	for i := 1; i < len(w.flushes); i++ {
+		if w.flushes[i] <= w.flushes[i-1] {
+			t.Fatalf("expected every flush to write more output, got %d after %d", w.flushes[i], w.flushes[i-1])
+		}
+	}
+}
+
+func TestRegisterPaths(t *testing.T) {
+	tmpler := NewTemplater(mapFS(map[string]string{
+		"partials/nav.html": `nav`,
+	}))
+	tmpler.Logger = discardLogger
+
+	tmpler.Register("backslash", `partials\nav.html`)
+	tmpler.Register("dotted", "partials/../partials/./nav.html")
+	tmpler.Register("escaped", "../secret.html")
+
+	for _, name := range []string{"backslash", "dotted"} {
+		if path, _ := tmpler.Lookup(name); path != "partials/nav.html" {
+			t.Errorf("%s: expected the path to be cleaned, got %q", name, path)
+		}
+	}
+
+	if err := tmpler.Ready(); err == nil || !strings.Contains(err.Error(), "../secret.html") {
+		t.Errorf("expected the escaping path to fail to load, got %v", err)
+	}
+
+	if err := tmpler.Preregister(`..\outside`); err == nil {
+		t.Error("expected Preregister to reject a path leading out of the FileSystem")
+	}
+}
+
+func BenchmarkDebugModTime(b *testing.B) {
+	for _, modTime := range []bool{false, true} {
+		b.Run(fmt.Sprintf("DebugModTime=%v", modTime), func(b *testing.B) {
+			debug := true
+
+			fsys := make(fstest.MapFS, 50)
+			for i := 0; i < 50; i++ {
+				fsys[fmt.Sprintf("page%d.html", i)] = &fstest.MapFile{
+					Data:    []byte(fmt.Sprintf(`<p>{{ . }} %d</p>`, i)),
+					ModTime: time.Unix(1700000000, 0),
+				}
+			}
+
+			tmpler := NewTemplater(fsys)
+			tmpler.Debug = &debug
+			tmpler.Logger = discardLogger
+			tmpler.DebugModTime = modTime
+			if err := tmpler.Preregister(); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				if err := tmpler.Execute(io.Discard, "page0", i); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestOutputFilters(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"page.html": `<body>{{ . }}</body>`,
+	})
+
+	wrap := func(prefix, suffix string) func([]byte) ([]byte, error) {
+		return func(in []byte) ([]byte, error) {
+			return []byte(prefix + string(in) + suffix), nil
+		}
+	}
+	tmpler.OutputFilters = []func([]byte) ([]byte, error){
+		wrap("[a", "a]"),
+		wrap("[b", "b]"),
+	}
+
+	if out := mustRender(t, tmpler, "page", "hi"); out != "[b[a<body>hi</body>a]b]" {
+		t.Errorf("expected the filters to be applied in order, got %q", out)
+	}
+
+	tmpler.OutputFilters = append(tmpler.OutputFilters, func([]byte) ([]byte, error) {
+		return nil, errors.New("filter failed")
+	})
+
+	var buf strings.Builder
+	if err := tmpler.Execute(&buf, "page", "hi"); err == nil {
+		t.Error("expected the filter error")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected nothing to be written, got %q", buf.String())
+	}
+}
+
+func TestPostProcessorPartialWrite(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"post.md": `# {{ . }}`,
+	})
+	tmpler.PostProcessors = map[string]PostProcessor{
+		".md": func(in []byte, w io.Writer) error {
+			w.Write([]byte("<h1>half"))
+			return errors.New("bad markdown")
+		},
+	}
+
+	var buf strings.Builder
+	if err := tmpler.Execute(&buf, "post", "Title"); err == nil {
+		t.Fatal("expected the processor error")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected nothing to be written, got %q", buf.String())
+	}
+}
+
+func TestRegisterString(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"page.html":     `<main>{{ template "greeting" . }}</main>`,
+		"greeting.html": `file`,
+	})
+
+	// It replaces the include of the same name.
+	tmpler.RegisterString("greeting", `<p>Hello, {{ . }}!</p>`)
+
+	if out := mustRender(t, tmpler, "page", "alice"); out != "<main><p>Hello, alice!</p></main>" {
+		t.Errorf("unexpected output %q", out)
+	}
+	if path, ok := tmpler.Lookup("greeting"); !ok || path != "" {
+		t.Errorf("expected no path for the string include, got %q, %v", path, ok)
+	}
+}
+
+func TestRegisterWithFuncs(t *testing.T) {
+	tmpler := NewTemplater(mapFS(map[string]string{
+		"en.html": `{{ greet . }}`,
+		"fr.html": `{{ greet . }}`,
+	}))
+	tmpler.Functions = template.FuncMap{
+		"greet": func(name string) string { return "Hello, " + name },
+	}
+	tmpler.Register("en", "en.html")
+	tmpler.RegisterWithFuncs("fr", "fr.html", template.FuncMap{
+		"greet": func(name string) string { return "Bonjour, " + name },
+	})
+
+	if out := mustRender(t, tmpler, "fr", "alice"); out != "Bonjour, alice" {
+		t.Errorf("expected the override, got %q", out)
+	}
+	if out := mustRender(t, tmpler, "en", "alice"); out != "Hello, alice" {
+		t.Errorf("expected the other template to be unaffected, got %q", out)
+	}
+}
+
+func TestReady(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"ok.html":     `ok`,
+		"broken.html": `{{ if }}`,
+	})
+
+	err := tmpler.Ready()
+	if err == nil || !strings.Contains(err.Error(), "template: broken:") {
+		t.Errorf("expected the parse error, got %v", err)
+	}
+
+	fixed := newTestTemplater(t, map[string]string{
+		"ok.html": `ok`,
+	})
+	for i := 0; i < 2; i++ {
+		if err := fixed.Ready(); err != nil {
+			t.Errorf("expected valid templates to be ready, got %v", err)
+		}
+	}
+}
+
+func BenchmarkExecuteMarkdown(b *testing.B) {
+	tmpler := newTestTemplater(b, map[string]string{
+		"post.md": "# {{ .Title }}\n{{ .Body }}",
+	})
+	tmpler.PostProcessors = map[string]PostProcessor{".md": upperMarkdown}
+	tmpler.Preload()
+
+	data := map[string]string{"Title": "Hello", "Body": "Lorem ipsum dolor sit amet."}
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if err := tmpler.Execute(io.Discard, "post", data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestPreregisterFunc(t *testing.T) {
+	tmpler := NewTemplater(mapFS(map[string]string{
+		"admin/users.html": `admin users`,
+		"site/users.html":  `site users`,
+		"site/draft.html":  `draft`,
+	}))
+
+	err := tmpler.PreregisterFunc(func(p string) (string, bool) {
+		if strings.Contains(p, "draft") {
+			return "", false
+		}
+		dir, file := path.Split(p)
+		return strings.TrimSuffix(file, path.Ext(file)) + "@" + strings.TrimSuffix(dir, "/"), true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := tmpler.RegisteredNames(); !slices.Equal(names, []string{"users@admin", "users@site"}) {
+		t.Errorf("unexpected names %q", names)
+	}
+	if out := mustRender(t, tmpler, "users@admin", nil); out != "admin users" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+func TestRegisterSandboxed(t *testing.T) {
+	tmpler := NewTemplater(mapFS(map[string]string{
+		"allowed.html":    `{{ upper . }}`,
+		"disallowed.html": `{{ secret }}`,
+		"partial.html":    `{{ secret }}`,
+		"indirect.html":   `{{ template "partial" }}`,
+	}))
+	tmpler.Functions = template.FuncMap{
+		"upper":  strings.ToUpper,
+		"secret": func() string { return "hunter2" },
+	}
+	tmpler.Register("partial", "partial.html")
+	tmpler.RegisterSandboxed("allowed", "allowed.html", []string{"upper"})
+	tmpler.RegisterSandboxed("disallowed", "disallowed.html", []string{"upper"})
+	tmpler.RegisterSandboxed("indirect", "indirect.html", []string{"upper"})
+
+	if out := mustRender(t, tmpler, "allowed", "hi"); out != "HI" {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	for _, name := range []string{"disallowed", "indirect"} {
+		out, err := tmpler.RenderString(name, nil)
+		if err == nil {
+			t.Errorf("%s: expected the disallowed function to fail, got %q", name, out)
+		}
+		if strings.Contains(out, "hunter2") {
+			t.Errorf("%s: disallowed function was called", name)
+		}
+	}
+}
+
+func TestPreregisterSkipsSymlinks(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, "page.html"), []byte("page"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("page.html", filepath.Join(dir, "link.html")); err != nil {
+		t.Skip("symlinks are not supported:", err)
+	}
+
+	tmpler := NewTemplater(os.DirFS(dir))
+	tmpler.Logger = discardLogger
+	if err := tmpler.Preregister(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !tmpler.Has("page") {
+		t.Error("expected the regular file to be registered")
+	}
+	if tmpler.Has("link") {
+		t.Error("expected the symlink to be skipped")
+	}
+}
+
+func TestReload(t *testing.T) {
+	fsys := mapFS(map[string]string{
+		"page.html": `old`,
+	})
+	tmpler := NewTemplater(fsys)
+	tmpler.Register("page", "page.html")
+
+	if out := mustRender(t, tmpler, "page", nil); out != "old" {
+		t.Fatalf("unexpected output %q", out)
+	}
+
+	fsys["page.html"] = &fstest.MapFile{Data: []byte(`{{ if }}`)}
+	if err := tmpler.Reload(); err == nil {
+		t.Fatal("expected the broken templates to fail to reload")
+	}
+	if out := mustRender(t, tmpler, "page", nil); out != "old" {
+		t.Errorf("expected the old templates to be kept, got %q", out)
+	}
+
+	fsys["page.html"] = &fstest.MapFile{Data: []byte(`new`)}
+	if err := tmpler.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if out := mustRender(t, tmpler, "page", nil); out != "new" {
+		t.Errorf("expected the new templates, got %q", out)
+	}
+}
+
+func TestErrorsChannel(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"page.html": `{{ fail }}`,
+	})
+	tmpler.Functions["fail"] = func() (string, error) { return "", errors.New("failed") }
+
+	errs := make(chan RenderFailure, 1)
+	tmpler.Errors = errs
+
+	for i := 0; i < 2; i++ {
+		// The second failure is dropped instead of blocking.
+		if err := tmpler.Execute(io.Discard, "page", nil); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+
+	select {
+	case failure := <-errs:
+		if failure.Name != "page" || failure.Path != "page.html" {
+			t.Errorf("unexpected failure %+v", failure)
+		}
+		if failure.Err == nil || !strings.Contains(failure.Err.Error(), "failed") {
+			t.Errorf("unexpected error %v", failure.Err)
+		}
+		if failure.Time.IsZero() {
+			t.Error("expected the failure time")
+		}
+	default:
+		t.Fatal("expected a failure to be sent")
+	}
+}
+
+func TestErrorTemplateNames(t *testing.T) {
+	broken := newTestTemplater(t, map[string]string{
+		"broken.html": `{{ if }}`,
+	})
+	if err := broken.Ready(); err == nil || !strings.Contains(err.Error(), "template: broken:1:") {
+		t.Errorf("expected the parse error to name the include, got %v", err)
+	}
+
+	failing := newTestTemplater(t, map[string]string{
+		"page.html": `<p>{{ .Missing.Field }}</p>`,
+	})
+	err := failing.Execute(io.Discard, "page", struct{}{})
+	if err == nil || !strings.Contains(err.Error(), "template: page:1:") {
+		t.Errorf("expected the execution error to name the include, got %v", err)
+	}
+	if err != nil && strings.Contains(err.Error(), "template: :") {
+		t.Errorf("expected no unnamed template in the error, got %v", err)
+	}
+
+	// The root template can never be executed by name.
+	var notRegistered ErrTemplateNotRegistered
+	if err := failing.Execute(io.Discard, rootName, nil); !errors.As(err, &notRegistered) {
+		t.Errorf("expected the root template not to be executable, got %v", err)
+	}
+}
+
+func TestRenderFailDepth(t *testing.T) {
+	defer func(depth int) { MaxRenderFailDepth = depth }(MaxRenderFailDepth)
+
+	tests := []struct {
+		depth int
+		out   string
+	}{
+		{depth: 1, out: errorFallback},
+		{depth: 2, out: "<p>error: failed</p>"},
+	}
+
+	for _, test := range tests {
+		MaxRenderFailDepth = test.depth
+
+		tmpler := newTestTemplater(t, map[string]string{
+			"page.html":   `{{ fail }}`,
+			"error.html":  `{{ fail }}`,
+			"simple.html": `<p>error: {{ . }}</p>`,
+		})
+		tmpler.Functions["fail"] = func() (string, error) { return "", errors.New("failed") }
+		tmpler.OnRenderFail = func(sub *Subtemplate, w io.Writer, err error) {
+			// The error template fails, so fall back to the simple one.
+			fallback := "error"
+			if sub.Name() == "error" {
+				fallback = "simple"
+			}
+			sub.Templater().RenderError(w, fallback, "failed")
+		}
+
+		var buf strings.Builder
+		if err := tmpler.Execute(&buf, "page", nil); err == nil {
+			t.Fatal("expected an error")
+		}
+		if buf.String() != test.out {
+			t.Errorf("depth %d: expected %q, got %q", test.depth, test.out, buf.String())
+		}
+	}
+}
+
+func TestExecuteToBuffer(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"greeting.html": `<p>Hi {{ . }}.</p>`,
+		"post.md":       `# {{ . }}`,
+		"fail.html":     `partial {{ fail }}`,
+	})
+	tmpler.Functions["fail"] = func() (string, error) { return "", errors.New("failed") }
+	tmpler.PostProcessors = map[string]PostProcessor{".md": upperMarkdown}
+
+	buf := bytes.NewBufferString("start:")
+
+	if err := tmpler.Subtemplate("greeting").ExecuteToBuffer(buf, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpler.Subtemplate("post").ExecuteToBuffer(buf, "Title"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpler.Subtemplate("fail").ExecuteToBuffer(buf, nil); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if out := buf.String(); out != "start:<p>Hi alice.</p><h1>Title</h1>" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"post.md": `# {{ . }}`,
+	})
+	tmpler.Logger = discardLogger
+	tmpler.PostProcessors = map[string]PostProcessor{
+		".md": func(in []byte, w io.Writer) error { panic("converter bug") },
+	}
+	tmpler.RecoverPanics = true
+
+	var failed error
+	tmpler.OnRenderFail = func(sub *Subtemplate, w io.Writer, err error) { failed = err }
+
+	err := tmpler.Execute(io.Discard, "post", "Title")
+	if err == nil || !strings.Contains(err.Error(), "converter bug") {
+		t.Errorf("expected the panic as an error, got %v", err)
+	}
+	if failed != err {
+		t.Errorf("expected the error to be routed through OnRenderFail, got %v", failed)
+	}
+}
+
+func TestDebugPerTemplater(t *testing.T) {
+	fsys := mapFS(map[string]string{
+		"page.html": `old`,
+	})
+
+	debug, production := true, false
+
+	debugTmpler := NewTemplater(fsys)
+	debugTmpler.Debug = &debug
+	debugTmpler.Logger = discardLogger
+	debugTmpler.Register("page", "page.html")
+
+	prodTmpler := NewTemplater(fsys)
+	prodTmpler.Debug = &production
+	prodTmpler.Register("page", "page.html")
+
+	for _, tmpler := range []*Templater{debugTmpler, prodTmpler} {
+		if out := mustRender(t, tmpler, "page", nil); out != "old" {
+			t.Fatalf("unexpected output %q", out)
+		}
+	}
+
+	fsys["page.html"] = &fstest.MapFile{Data: []byte(`new`)}
+
+	if out := mustRender(t, debugTmpler, "page", nil); out != "new" {
+		t.Errorf("expected the debug Templater to reload, got %q", out)
+	}
+	if out := mustRender(t, prodTmpler, "page", nil); out != "old" {
+		t.Errorf("expected the other Templater to keep its templates, got %q", out)
+	}
+}
+
+func TestPanicLogger(t *testing.T) {
+	h := &recordHandler{}
+
+	tmpler := NewTemplater(nil)
+	tmpler.Logger = slog.New(h)
+	tmpler.Func("f", strings.ToUpper)
+
+	defer func() {
+		p := recover()
+		if p != "error: duplicate function with name f" {
+			t.Errorf("unexpected panic %v", p)
+		}
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if len(h.messages) != 1 || h.messages[0] != "ERROR error: duplicate function with name f" {
+			t.Errorf("expected the panic to be logged to the Logger, got %q", h.messages)
+		}
+	}()
+
+	tmpler.Func("f", strings.ToLower)
+}
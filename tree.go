@@ -0,0 +1,325 @@
+package tmplutil
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"text/template/parse"
+)
+
+// Trees returns the parse trees of all registered includes by name, loading the
+// templates if they're not loaded yet. This is meant for tooling, such as
+// linters that look for unused variables or functions. The trees are shared
+// with the loaded templates, so mutating them is unsupported.
+func (tmpler *Templater) Trees() map[string]*parse.Tree {
+	t := tmpler.load()
+
+	trees := make(map[string]*parse.Tree, len(t.includes))
+	for name := range t.includes {
+		set := t.set
+		if layout, ok := t.layouts[name]; ok {
+			set = layout.set
+		}
+		trees[name] = set.tree(name)
+	}
+
+	return trees
+}
+
+// Dependencies returns the sorted names of all includes that the include with
+// the given name depends on, following {{template}} references recursively,
+// e.g. to know which pages to invalidate when a partial changes. For pages
+// registered with a layout, the layout and its dependencies are included.
+// References to templates that don't exist are logged and skipped.
+func (tmpler *Templater) Dependencies(name string) ([]string, error) {
+	t := tmpler.load()
+
+	if _, ok := t.includes[name]; !ok {
+		return nil, ErrTemplateNotRegistered{Name: name}
+	}
+
+	set := t.set
+	roots := []string{name}
+	if layout, ok := t.layouts[name]; ok {
+		set = layout.set
+		roots = append(roots, layout.name)
+	}
+
+	visited := make(map[string]struct{})
+	deps := make(map[string]struct{})
+
+	var visit func(tmpl string)
+	visit = func(tmpl string) {
+		if _, ok := visited[tmpl]; ok {
+			return
+		}
+		visited[tmpl] = struct{}{}
+
+		tree := set.tree(tmpl)
+		if tree == nil {
+			tmpler.logf(slog.LevelWarn, "warning: %q depends on missing template %q", name, tmpl)
+			return
+		}
+
+		// Blocks defined within an include are parsed from its file, so the
+		// include is a dependency as well.
+		if _, ok := t.includes[tree.ParseName]; ok {
+			deps[tree.ParseName] = struct{}{}
+		}
+
+		for _, ref := range templateRefs(tree) {
+			visit(ref)
+		}
+	}
+
+	for _, root := range roots {
+		visit(root)
+	}
+
+	delete(deps, name)
+
+	names := make([]string, 0, len(deps))
+	for dep := range deps {
+		names = append(names, dep)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// templateRefs returns the sorted names of all templates referenced by
+// {{template}} calls within the given tree.
+func templateRefs(tree *parse.Tree) []string {
+	if tree == nil {
+		return nil
+	}
+
+	refs := make(map[string]struct{})
+	walkTemplateRefs(tree.Root, refs)
+
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func walkTemplateRefs(node parse.Node, refs map[string]struct{}) {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return
+		}
+		for _, n := range node.Nodes {
+			walkTemplateRefs(n, refs)
+		}
+	case *parse.TemplateNode:
+		refs[node.Name] = struct{}{}
+	case *parse.IfNode:
+		walkTemplateRefs(node.List, refs)
+		walkTemplateRefs(node.ElseList, refs)
+	case *parse.RangeNode:
+		walkTemplateRefs(node.List, refs)
+		walkTemplateRefs(node.ElseList, refs)
+	case *parse.WithNode:
+		walkTemplateRefs(node.List, refs)
+		walkTemplateRefs(node.ElseList, refs)
+	}
+}
+
+// CycleError is returned by Validate when templates reference each other in a
+// cycle.
+type CycleError struct {
+	// Cycle is the list of templates in the cycle, starting and ending with
+	// the same template.
+	Cycle []string
+}
+
+// Error formats the cycle like "cycle: a -> b -> a".
+func (err *CycleError) Error() string {
+	return "cycle: " + strings.Join(err.Cycle, " -> ")
+}
+
+// findCycle looks for a {{template}} cycle reachable from the given templates.
+// It returns nil if there's none.
+func findCycle(set templateSet, roots []string) error {
+	const (
+		visiting = iota + 1
+		visited
+	)
+
+	states := make(map[string]int)
+	var stack []string
+
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		switch states[name] {
+		case visiting:
+			for i, n := range stack {
+				if n == name {
+					cycle := append([]string(nil), stack[i:]...)
+					return append(cycle, name)
+				}
+			}
+		case visited:
+			return nil
+		}
+
+		states[name] = visiting
+		stack = append(stack, name)
+
+		for _, ref := range templateRefs(set.tree(name)) {
+			if cycle := visit(ref); cycle != nil {
+				return cycle
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		states[name] = visited
+		return nil
+	}
+
+	for _, root := range roots {
+		if cycle := visit(root); cycle != nil {
+			return &CycleError{cycle}
+		}
+	}
+
+	return nil
+}
+
+// findCycles returns every {{template}} cycle found in the given templates,
+// joined together. Cycles in pages registered with a layout are prefixed with
+// the page's name.
+func findCycles(t *templates) error {
+	var errs []error
+
+	if err := findCycle(t.set, t.set.names()); err != nil {
+		errs = append(errs, err)
+	}
+
+	pages := make([]string, 0, len(t.layouts))
+	for name := range t.layouts {
+		pages = append(pages, name)
+	}
+	sort.Strings(pages)
+
+	for _, name := range pages {
+		layout := t.layouts[name]
+		if err := findCycle(layout.set, []string{layout.name}); err != nil {
+			errs = append(errs, fmt.Errorf("page %q: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// logCycles logs any {{template}} cycle found in the given templates. Cycles
+// are only logged and not treated as errors when loading, since a template may
+// recurse into itself on purpose, e.g. to render a tree. Validate returns them.
+func (tmpler *Templater) logCycles(t *templates) {
+	if err := findCycles(t); err != nil {
+		tmpler.logf(slog.LevelWarn, "warning: %v", err)
+	}
+}
+
+// checkRefs returns an error listing every {{template}} call that references a
+// template that doesn't exist in the given templates.
+func checkRefs(t *templates) error {
+	missing := make(map[string]struct{})
+
+	check := func(set templateSet) {
+		for _, name := range set.names() {
+			for _, ref := range templateRefs(set.tree(name)) {
+				if set.tree(ref) == nil {
+					missing[fmt.Sprintf("%q references %q", name, ref)] = struct{}{}
+				}
+			}
+		}
+	}
+
+	check(t.set)
+	for _, layout := range t.layouts {
+		check(layout.set)
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	refs := make([]string, 0, len(missing))
+	for ref := range missing {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+
+	return fmt.Errorf("missing template references: %s", strings.Join(refs, "; "))
+}
+
+// funcNames returns the names of all functions called within the given
+// templates, including the ones of pages parsed into their own copy.
+func funcNames(t *templates) map[string]struct{} {
+	names := make(map[string]struct{})
+
+	walk := func(set templateSet) {
+		for _, name := range set.names() {
+			if tree := set.tree(name); tree != nil {
+				walkFuncNames(tree.Root, names)
+			}
+		}
+	}
+
+	walk(t.set)
+	for _, layout := range t.layouts {
+		walk(layout.set)
+	}
+
+	return names
+}
+
+func walkFuncNames(node parse.Node, names map[string]struct{}) {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return
+		}
+		for _, n := range node.Nodes {
+			walkFuncNames(n, names)
+		}
+	case *parse.ActionNode:
+		walkFuncNames(node.Pipe, names)
+	case *parse.PipeNode:
+		if node == nil {
+			return
+		}
+		for _, cmd := range node.Cmds {
+			walkFuncNames(cmd, names)
+		}
+	case *parse.CommandNode:
+		for _, arg := range node.Args {
+			walkFuncNames(arg, names)
+		}
+	case *parse.ChainNode:
+		walkFuncNames(node.Node, names)
+	case *parse.IdentifierNode:
+		names[node.Ident] = struct{}{}
+	case *parse.TemplateNode:
+		walkFuncNames(node.Pipe, names)
+	case *parse.IfNode:
+		walkFuncNames(node.Pipe, names)
+		walkFuncNames(node.List, names)
+		walkFuncNames(node.ElseList, names)
+	case *parse.RangeNode:
+		walkFuncNames(node.Pipe, names)
+		walkFuncNames(node.List, names)
+		walkFuncNames(node.ElseList, names)
+	case *parse.WithNode:
+		walkFuncNames(node.Pipe, names)
+		walkFuncNames(node.List, names)
+		walkFuncNames(node.ElseList, names)
+	}
+}
@@ -0,0 +1,45 @@
+package tmplutil
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTrees(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"index.html":        `{{ template "partials/nav" . }}`,
+		"about.html":        `about`,
+		"partials/nav.html": `nav`,
+	})
+
+	trees := tmpler.Trees()
+
+	for _, name := range []string{"index", "about", "partials/nav"} {
+		tree, ok := trees[name]
+		if !ok || tree == nil || tree.Root == nil {
+			t.Errorf("missing tree for %q", name)
+		}
+	}
+	if len(trees) != 3 {
+		t.Errorf("expected 3 trees, got %d", len(trees))
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"page.html":            `{{ template "partials/header" . }}{{ template "partials/footer" . }}`,
+		"partials/header.html": `{{ template "partials/logo" . }}`,
+		"partials/footer.html": `footer`,
+		"partials/logo.html":   `logo`,
+	})
+
+	deps, err := tmpler.Dependencies("page")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{"partials/footer", "partials/header", "partials/logo"}
+	if !slices.Equal(deps, expect) {
+		t.Errorf("expected %q, got %q", expect, deps)
+	}
+}
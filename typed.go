@@ -0,0 +1,119 @@
+package tmplutil
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Render executes the subtemplate with the given data. It is the same as
+// sub.Execute, except the type of the data is spelled out at the call site.
+func Render[T any](sub *Subtemplate, w io.Writer, v T) error {
+	return sub.Execute(w, v)
+}
+
+// TypedSubtemplate is a subtemplate that may only be executed with data of type
+// T. It doesn't check that the template only uses fields that T has, but it
+// documents what the template expects and prevents passing the wrong data
+// entirely. For example:
+//
+//	type indexData struct {
+//		Username string
+//	}
+//
+//	var index = tmplutil.RegisterTyped[indexData](tmpler, "index", "index.html")
+//
+//	func render(w http.ResponseWriter, r *http.Request) {
+//		index.Execute(w, indexData{Username: "alice"}) // ok
+//		index.Execute(w, struct{}{})                   // compile error
+//	}
+//
+// Methods of Subtemplate that take untyped data, such as ServeContent, are not
+// exposed, so that the data cannot be passed untyped by accident.
+type TypedSubtemplate[T any] struct {
+	sub *Subtemplate
+}
+
+// Typed wraps the given subtemplate into a TypedSubtemplate.
+func Typed[T any](sub *Subtemplate) TypedSubtemplate[T] {
+	return TypedSubtemplate[T]{sub}
+}
+
+// RegisterTyped registers a subtemplate like Register and wraps it into a
+// TypedSubtemplate. It's the same as Typed[T](tmpler.Register(name, path)).
+func RegisterTyped[T any](tmpler *Templater, name, path string) TypedSubtemplate[T] {
+	return Typed[T](tmpler.Register(name, path))
+}
+
+// Name gets the subtemplate's name.
+func (sub TypedSubtemplate[T]) Name() string {
+	return sub.sub.Name()
+}
+
+// Exists returns true if the subtemplate is registered.
+func (sub TypedSubtemplate[T]) Exists() bool {
+	return sub.sub.Exists()
+}
+
+// Execute executes the subtemplate.
+func (sub TypedSubtemplate[T]) Execute(w io.Writer, v T) error {
+	return sub.sub.Execute(w, v)
+}
+
+// ExecuteContext executes the subtemplate with the given context. Refer to
+// Templater.ExecuteContext.
+func (sub TypedSubtemplate[T]) ExecuteContext(ctx context.Context, w io.Writer, v T) error {
+	return sub.sub.ExecuteContext(ctx, w, v)
+}
+
+// ExecuteHTTP executes the subtemplate into the response with the given status
+// code. Refer to Subtemplate.ExecuteHTTP.
+func (sub TypedSubtemplate[T]) ExecuteHTTP(w http.ResponseWriter, status int, v T) error {
+	return sub.sub.ExecuteHTTP(w, status, v)
+}
+
+// ExecuteBlock executes only the block with the given name defined within the
+// subtemplate. Refer to Templater.ExecuteBlock.
+func (sub TypedSubtemplate[T]) ExecuteBlock(w io.Writer, block string, v T) error {
+	return sub.sub.ExecuteBlock(w, block, v)
+}
+
+// ExecuteToBuffer executes the subtemplate and appends its output to the given
+// buffer. Refer to Subtemplate.ExecuteToBuffer.
+func (sub TypedSubtemplate[T]) ExecuteToBuffer(buf *bytes.Buffer, v T) error {
+	return sub.sub.ExecuteToBuffer(buf, v)
+}
+
+// CachedExecute executes the subtemplate using the output cache. Refer to
+// Subtemplate.CachedExecute.
+func (sub TypedSubtemplate[T]) CachedExecute(w io.Writer, key string, ttl time.Duration, data func() T) error {
+	return sub.sub.CachedExecute(w, key, ttl, func() interface{} { return data() })
+}
+
+// RenderString executes the subtemplate and returns its output as a string.
+func (sub TypedSubtemplate[T]) RenderString(v T) (string, error) {
+	return sub.sub.RenderString(v)
+}
+
+// WriteTo executes the subtemplate and returns the number of bytes written to
+// w. Refer to Subtemplate.WriteTo.
+func (sub TypedSubtemplate[T]) WriteTo(w io.Writer, v T) (int64, error) {
+	return sub.sub.WriteTo(w, v)
+}
+
+// Handler creates an HTTP handler that renders the subtemplate with the data
+// returned by the given function. Refer to Subtemplate.Handler.
+func (sub TypedSubtemplate[T]) Handler(data func(r *http.Request) (T, error)) http.Handler {
+	return sub.sub.Handler(func(r *http.Request) (interface{}, error) {
+		return data(r)
+	})
+}
+
+// CheckData checks that the given data satisfies the subtemplate, e.g. in
+// tests to catch fields of T that the template references but T lacks. Refer
+// to Templater.CheckData.
+func (sub TypedSubtemplate[T]) CheckData(v T) error {
+	return sub.sub.tmpl.CheckData(sub.sub.name, v)
+}
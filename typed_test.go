@@ -0,0 +1,72 @@
+package tmplutil
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+type typedPage struct {
+	Title string
+}
+
+func TestTypedSubtemplate(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"page.html": `<h1>{{ .Title }}</h1>`,
+	})
+
+	page := Typed[typedPage](tmpler.Subtemplate("page"))
+
+	out, err := page.RenderString(typedPage{Title: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "<h1>Hello</h1>" {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	if err := page.CheckData(typedPage{}); err != nil {
+		t.Errorf("expected typedPage to satisfy the template, got %v", err)
+	}
+}
+
+func TestTypedSubtemplateCheckData(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"page.html": `<h1>{{ .Title }}</h1><p>{{ .Body }}</p>`,
+	})
+
+	page := Typed[typedPage](tmpler.Subtemplate("page"))
+	if err := page.CheckData(typedPage{}); err == nil {
+		t.Error("expected CheckData to catch the missing Body field")
+	}
+
+	untyped := Typed[map[string]string](tmpler.Subtemplate("page"))
+	if err := untyped.CheckData(map[string]string{"Title": "Hello"}); err == nil {
+		t.Error("expected CheckData to catch the missing Body key")
+	}
+}
+
+func ExampleRegisterTyped() {
+	tmpler := NewTemplater(fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte(`Hello, {{ .Username }}!`)},
+	})
+
+	type indexData struct {
+		Username string
+	}
+
+	index := RegisterTyped[indexData](tmpler, "index", "index.html")
+
+	if err := index.Execute(os.Stdout, indexData{Username: "alice"}); err != nil {
+		fmt.Println(err)
+	}
+
+	// Passing anything but indexData doesn't compile:
+	//
+	//	index.Execute(os.Stdout, struct{ Other int }{})
+	//	index.ExecuteHTTP(w, http.StatusOK, struct{ Other int }{})
+
+	// Output:
+	// Hello, alice!
+}
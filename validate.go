@@ -0,0 +1,105 @@
+package tmplutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Validate parses every registered include on its own and returns all parse
+// errors joined together, rather than stopping at the first one like Load
+// does. Each error is prefixed with the include's name and path. This is useful
+// for checking that all templates compile in tests.
+//
+// If all includes parse, then {{template}} cycles are returned as CycleErrors,
+// e.g. "cycle: a -> b -> a", even though loading allows them. If
+// ValidateExecute is true as well, then every template is also executed with
+// nil data, and the execution errors are returned too.
+func (tmpler *Templater) Validate() error {
+	tmpler.tmplMu.Lock()
+	defer tmpler.tmplMu.Unlock()
+
+	names := make([]string, 0, len(tmpler.Includes))
+	for name := range tmpler.Includes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	sources := make(map[string]string, len(names))
+
+	for _, name := range names {
+		path := tmpler.Includes[name]
+
+		src, err := tmpler.readSource(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s (%s): %w", name, path, err))
+			continue
+		}
+
+		sources[name] = src
+
+		if err := tmpler.newTemplateSet().parse(name, sources[name]); err != nil {
+			errs = append(errs, fmt.Errorf("%s (%s): %w", name, path, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	t, err := tmpler.parseSources(func(name string) (string, error) {
+		return sources[name], nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := findCycles(t); err != nil {
+		errs = append(errs, err)
+	}
+
+	if !tmpler.ValidateExecute {
+		return errors.Join(errs...)
+	}
+
+	for _, name := range names {
+		if err := t.execute(io.Discard, name, nil); err != nil {
+			errs = append(errs, fmt.Errorf("%s (%s): %w", name, tmpler.Includes[name], err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// CheckData executes the template with the given data and discards the output,
+// returning an error if the data doesn't satisfy the template. Unlike Execute,
+// referencing a key that is missing from a map is also an error, as if the
+// templates had the "missingkey=error" option. This is useful for catching
+// drift between view models and templates in tests.
+//
+// Referencing a missing struct field is always an error, but only when the
+// reference is executed, so v should exercise the branches to be checked.
+// CheckData parses the templates anew on every call, so it should not be used
+// outside of tests.
+func (tmpler *Templater) CheckData(tmpl string, v interface{}) error {
+	tmpler.tmplMu.Lock()
+	t, err := tmpler.parseSources(tmpler.readSource)
+	tmpler.tmplMu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if _, ok := t.includes[tmpl]; !ok {
+		return ErrTemplateNotRegistered{Name: tmpl}
+	}
+
+	t.set.option("missingkey=error")
+	for _, layout := range t.layouts {
+		layout.set.option("missingkey=error")
+	}
+
+	return t.execute(io.Discard, tmpl, v)
+}
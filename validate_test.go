@@ -0,0 +1,95 @@
+package tmplutil
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"ok.html":     `<p>{{ . }}</p>`,
+		"broken.html": `{{ if }}`,
+		"other.html":  `{{ end }}`,
+	})
+
+	err := tmpler.Validate()
+	if err == nil {
+		t.Fatal("expected the broken templates to fail")
+	}
+
+	// Every broken template is reported, not only the first one.
+	for _, name := range []string{"broken (broken.html)", "other (other.html)"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("expected %q in the error, got %v", name, err)
+		}
+	}
+	if strings.Contains(err.Error(), "ok.html") {
+		t.Errorf("expected the valid template not to be reported, got %v", err)
+	}
+}
+
+func TestValidateExecute(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"ok.html":   `<p>{{ . }}</p>`,
+		"fail.html": `{{ fail }}`,
+	})
+	tmpler.Functions["fail"] = func() (string, error) { return "", errors.New("failed") }
+
+	if err := tmpler.Validate(); err != nil {
+		t.Fatalf("expected the templates to parse, got %v", err)
+	}
+
+	tmpler.ValidateExecute = true
+
+	err := tmpler.Validate()
+	if err == nil || !strings.Contains(err.Error(), "fail (fail.html)") {
+		t.Errorf("expected the execution error, got %v", err)
+	}
+}
+
+func TestCheckData(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"profile.html": `<p>{{ .Name }} ({{ .Email }})</p>`,
+	})
+
+	if err := tmpler.CheckData("profile", map[string]string{
+		"Name":  "alice",
+		"Email": "alice@example.com",
+	}); err != nil {
+		t.Errorf("expected the complete data to pass, got %v", err)
+	}
+
+	err := tmpler.CheckData("profile", map[string]string{"Name": "alice"})
+	if err == nil || !strings.Contains(err.Error(), `"Email"`) {
+		t.Errorf("expected the missing key to be reported, got %v", err)
+	}
+
+	// Execute still renders the missing key as empty.
+	if out := mustRender(t, tmpler, "profile", map[string]string{"Name": "alice"}); out != "<p>alice ()</p>" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+func TestValidateCycle(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{
+		"a.html":    `{{ template "b" . }}`,
+		"b.html":    `{{ template "a" . }}`,
+		"page.html": `page`,
+	})
+
+	err := tmpler.Validate()
+
+	var cycle *CycleError
+	if !errors.As(err, &cycle) {
+		t.Fatalf("expected a CycleError, got %v", err)
+	}
+	if err.Error() != "cycle: a -> b -> a" {
+		t.Errorf("unexpected error %q", err)
+	}
+
+	// Loading allows cycles, since templates may recurse on purpose.
+	if err := tmpler.Ready(); err != nil {
+		t.Errorf("expected the templates to load, got %v", err)
+	}
+}
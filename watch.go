@@ -0,0 +1,129 @@
+package tmplutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ErrNotWatchable is returned by Watch if the FileSystem is not backed by any
+// real directory, such as an embed.FS.
+var ErrNotWatchable = errors.New("filesystem is not backed by a directory")
+
+// Watch watches the directories of the registered includes for changes and
+// invalidates the include whose file is changed, so that only that file is
+// read again. It blocks until the context is cancelled or the watcher fails.
+//
+// While Watch is running, the loaded templates are reused even in DebugMode
+// instead of being reparsed on every execution.
+//
+// The FileSystem must be a filesystem created by os.DirFS, or an override of
+// one. Otherwise, ErrNotWatchable is returned. Only includes that are
+// registered before Watch is called are watched.
+func (tmpler *Templater) Watch(ctx context.Context) error {
+	dirs := osDirs(tmpler.FileSystem)
+	if len(dirs) == 0 {
+		return ErrNotWatchable
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	tmpler.tmplMu.Lock()
+	files := make(map[string]string, len(tmpler.Includes)*len(dirs))
+	for name, incl := range tmpler.Includes {
+		if _, ok := tmpler.strSrcs[name]; ok {
+			continue
+		}
+		for _, dir := range dirs {
+			files[filepath.Join(dir, filepath.FromSlash(incl))] = name
+		}
+	}
+	tmpler.tmplMu.Unlock()
+
+	watched := make(map[string]struct{})
+	for file := range files {
+		dir := filepath.Dir(file)
+		if _, ok := watched[dir]; ok {
+			continue
+		}
+		watched[dir] = struct{}{}
+
+		// Skip directories that don't exist, since override directories don't
+		// have to contain every include.
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+
+		if err := watcher.Add(dir); err != nil {
+			return fmt.Errorf("failed to watch %q: %w", dir, err)
+		}
+	}
+
+	atomic.AddInt32(&tmpler.watching, 1)
+	defer atomic.AddInt32(&tmpler.watching, -1)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case err := <-watcher.Errors:
+			return fmt.Errorf("watcher error: %w", err)
+
+		case ev := <-watcher.Events:
+			name, ok := files[filepath.Clean(ev.Name)]
+			if !ok {
+				continue
+			}
+
+			if tmpler.debug() {
+				tmpler.logf(slog.LevelDebug, "reloading after %s changed at %s", name, ev.Name)
+			}
+
+			tmpler.Invalidate(name)
+		}
+	}
+}
+
+// osDirs returns the directories that back the given filesystem.
+func osDirs(fsys fs.FS) []string {
+	switch fsys := fsys.(type) {
+	case overrideFS:
+		var dirs []string
+		for _, layer := range fsys {
+			dirs = append(dirs, osDirs(layer)...)
+		}
+		return dirs
+	case mergeFS:
+		var dirs []string
+		for _, fsys := range fsys {
+			dirs = append(dirs, osDirs(fsys)...)
+		}
+		return dirs
+	case *WritableFS:
+		return osDirs(fsys.overrideFS)
+	case filterFS:
+		return osDirs(fsys.fs)
+	}
+
+	// os.DirFS doesn't expose its root directory, so we dig it out of the
+	// underlying string type.
+	v := reflect.ValueOf(fsys)
+	if v.Kind() == reflect.String && v.Type().PkgPath() == "os" {
+		return []string{v.String()}
+	}
+
+	return nil
+}
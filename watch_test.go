@@ -0,0 +1,68 @@
+package tmplutil
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "page.html")
+
+	if err := os.WriteFile(file, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpler := NewTemplater(os.DirFS(dir))
+	tmpler.Register("page", "page.html")
+
+	if out := mustRender(t, tmpler, "page", nil); out != "old" {
+		t.Fatalf("unexpected output %q", out)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- tmpler.Watch(ctx) }()
+
+	defer func() {
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Errorf("expected Watch to stop with the context, got %v", err)
+		}
+	}()
+
+	waitFor(t, func() bool { return atomic.LoadInt32(&tmpler.watching) > 0 })
+
+	if err := os.WriteFile(file, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, func() bool { return mustRender(t, tmpler, "page", nil) == "new" })
+}
+
+func TestWatchNotWatchable(t *testing.T) {
+	tmpler := newTestTemplater(t, map[string]string{"page.html": "page"})
+
+	if err := tmpler.Watch(context.Background()); !errors.Is(err, ErrNotWatchable) {
+		t.Errorf("expected ErrNotWatchable, got %v", err)
+	}
+}
+
+// waitFor polls the condition until it's true, failing the test if it isn't
+// within a few seconds.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}